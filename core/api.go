@@ -0,0 +1,232 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+)
+
+// Client runs tests against Config.Hosts.
+type Client struct {
+	app *Config
+}
+
+// NewClient creates client for configuration app.
+func NewClient(app *Config) *Client {
+	return &Client{app: app}
+}
+
+// Run performs the test until completion or ctx cancellation.
+// Interrupted test returns results collected so far along with ctx error.
+func (cl *Client) Run(ctx context.Context) (*Result, error) {
+	if errSetup := cl.app.Setup(); errSetup != nil {
+		return nil, errSetup
+	}
+	if len(cl.app.Hosts) == 0 {
+		return nil, errors.New("client: no hosts")
+	}
+
+	if cl.app.Web != "" {
+		ctxWeb, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		defer cancel() // stop web server before waiting for it
+		if errWeb := serveWeb(ctxWeb, &wg, cl.app, "client"); errWeb != nil {
+			return nil, errWeb
+		}
+	}
+
+	if cl.app.Tui {
+		cl.app.tui = newTuiObserver(os.Stdout)
+		stop := cl.app.tui.run(cl.app.Opt.ReportInterval)
+		defer stop()
+	}
+
+	result := open(ctx, cl.app)
+	result.reportFailures()
+
+	if errCtx := ctx.Err(); errCtx != nil {
+		return result, errCtx
+	}
+
+	if failed := result.Failed(); failed == len(result.Connections) {
+		return result, fmt.Errorf("client: all %d connections failed", failed)
+	}
+
+	return result, nil
+}
+
+// Open runs client test, logging errors. See Client for structured results.
+func Open(ctx context.Context, app *Config) {
+	if _, err := NewClient(app).Run(ctx); err != nil {
+		slog.Error("open", "err", err)
+	}
+}
+
+// Server serves tests on Config.Listeners.
+type Server struct {
+	app         *Config
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	addrs       []net.Addr
+	metricsAddr net.Addr
+	apiAddr     net.Addr
+}
+
+// NewServer creates server for configuration app.
+func NewServer(app *Config) *Server {
+	return &Server{app: app}
+}
+
+// Start binds all listeners and serves in background until ctx
+// cancellation or Shutdown.
+func (s *Server) Start(ctx context.Context) error {
+	if errSetup := s.app.Setup(); errSetup != nil {
+		return errSetup
+	}
+
+	app := s.app
+
+	if app.TlsSelfSigned && app.tlsServerCert == nil {
+		cert, errCert := generateCert()
+		if errCert != nil {
+			return fmt.Errorf("server: generating self-signed certificate: %v", errCert)
+		}
+		app.tlsServerCert = &cert
+		if app.TlsMode == TLSOff {
+			app.TlsMode = TLSOptional
+			app.Tls = true
+		}
+		logResult("server: self-signed certificate", "fingerprint", s.TLSFingerprint()) // shown even in quiet mode
+	}
+
+	if app.TlsClientCA != "" {
+		// client authentication must not silently degrade to open plain TCP
+		if !app.Tls || (app.tlsServerCert == nil && (!fileExists(app.TlsKey) || !fileExists(app.TlsCert))) {
+			return fmt.Errorf("server: clientCA requires TLS with key=%s cert=%s", app.TlsKey, app.TlsCert)
+		}
+		app.TlsMode = TLSRequired
+		slog.Info("server: requiring client certificates", "clientCA", app.TlsClientCA)
+	}
+
+	if app.Tls && app.tlsServerCert == nil {
+		for _, f := range []string{app.TlsKey, app.TlsCert} {
+			if fileExists(f) {
+				continue
+			}
+			if app.TlsMode == TLSRequired {
+				return fmt.Errorf("server: TLS required: file not found: %s", f)
+			}
+			slog.Warn("TLS file not found - disabling TLS", "file", f)
+			app.disableTLS()
+			break
+		}
+	}
+
+	if app.TlsMode == TLSRequired {
+		slog.Info("server: TLS required, UDP disabled")
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	if app.Metrics != "" {
+		app.metrics = newServerMetrics()
+		addr, errMetrics := serveHandler(ctx, &s.wg, app.Metrics, "/metrics", app.metrics)
+		if errMetrics != nil {
+			s.Shutdown()
+			return errMetrics
+		}
+		s.metricsAddr = addr
+	}
+
+	if app.Web != "" {
+		if errWeb := serveWeb(ctx, &s.wg, app, "server"); errWeb != nil {
+			s.Shutdown()
+			return errWeb
+		}
+	}
+
+	if app.Api != "" {
+		addr, errAPI := serveHandler(ctx, &s.wg, app.Api, "/", newRestAPI(ctx, &s.wg, app))
+		if errAPI != nil {
+			s.Shutdown()
+			return errAPI
+		}
+		s.apiAddr = addr
+	}
+
+	for _, h := range app.Listeners {
+		hh := appendPortIfMissing(h, app.DefaultPort)
+
+		addrTCP, errTCP := ListenTCP(ctx, app, &s.wg, hh)
+		if errTCP != nil {
+			s.Shutdown()
+			return errTCP
+		}
+		s.addrs = append(s.addrs, addrTCP)
+
+		if app.TlsMode == TLSRequired {
+			continue // UDP cannot use TLS
+		}
+
+		addrUDP, errUDP := ListenUDP(ctx, app, &s.wg, hh)
+		if errUDP != nil {
+			s.Shutdown()
+			return errUDP
+		}
+		s.addrs = append(s.addrs, addrUDP)
+	}
+
+	return nil
+}
+
+// Addrs returns bound listener addresses.
+func (s *Server) Addrs() []net.Addr {
+	return s.addrs
+}
+
+// MetricsAddr returns bound metrics address, nil if disabled.
+func (s *Server) MetricsAddr() net.Addr {
+	return s.metricsAddr
+}
+
+// APIAddr returns bound HTTP API address, nil if disabled.
+func (s *Server) APIAddr() net.Addr {
+	return s.apiAddr
+}
+
+// TLSFingerprint returns SHA-256 fingerprint of generated self-signed
+// certificate, empty if none.
+func (s *Server) TLSFingerprint() string {
+	if s.app.tlsServerCert == nil {
+		return ""
+	}
+	return Fingerprint(s.app.tlsServerCert.Certificate[0])
+}
+
+// Wait blocks until server is shut down.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Shutdown stops listeners, ends active tests and waits for completion.
+func (s *Server) Shutdown() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Serve runs server until ctx cancellation, logging errors. See Server.
+func Serve(ctx context.Context, app *Config) {
+	s := NewServer(app)
+	if err := s.Start(ctx); err != nil {
+		slog.Error("serve", "err", err)
+		return
+	}
+	s.Wait()
+}
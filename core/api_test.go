@@ -0,0 +1,433 @@
+package core
+
+import (
+	"context"
+	"encoding/gob"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countObserver struct {
+	NopObserver
+	mutex       sync.Mutex
+	streams     int
+	connections int
+	tests       int
+}
+
+func (o *countObserver) StreamDone(s Stream, result StreamResult) {
+	o.mutex.Lock()
+	o.streams++
+	o.mutex.Unlock()
+}
+
+func (o *countObserver) ConnectionDone(c *ConnectionResult) {
+	o.mutex.Lock()
+	o.connections++
+	o.mutex.Unlock()
+}
+
+func (o *countObserver) TestDone(r *Result) {
+	o.mutex.Lock()
+	o.tests++
+	o.mutex.Unlock()
+}
+
+func TestClientServer(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	const size = 1000000
+
+	obs := &countObserver{}
+
+	client := NewClient(&Config{
+		Hosts:          HostList{host},
+		Connections:    2,
+		ReportInterval: "1s",
+		Bytes:          "1MB",
+		Opt:            testOptions,
+		Observers:      []Observer{obs},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := client.Run(ctx)
+	if err != nil {
+		t.Fatalf("client run: %v", err)
+	}
+
+	if len(result.Connections) != 2 {
+		t.Fatalf("connections=%d wanted=2", len(result.Connections))
+	}
+
+	for _, c := range result.Connections {
+		if c.Err != nil {
+			t.Errorf("connection %d: %v", c.Index, c.Err)
+		}
+		if c.Input.Bytes != size || c.Output.Bytes != size {
+			t.Errorf("connection %d: input=%d output=%d wanted=%d", c.Index, c.Input.Bytes, c.Output.Bytes, size)
+		}
+		if c.Final == nil {
+			t.Errorf("connection %d: missing final counters", c.Index)
+			continue
+		}
+		if c.Final.ReadBytes != size || c.Final.WriteBytes != size {
+			t.Errorf("connection %d: final=%v wanted=%d", c.Index, *c.Final, size)
+		}
+	}
+
+	if obs.streams != 4 || obs.connections != 2 || obs.tests != 1 {
+		t.Errorf("observer: streams=%d connections=%d tests=%d wanted=4 2 1", obs.streams, obs.connections, obs.tests)
+	}
+}
+
+func TestClientLimited(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	const size = 500000
+
+	// 500KB at 4 Mbps takes 1s, longer than totalDuration
+	for _, passive := range []bool{true, false} {
+		client := NewClient(&Config{
+			Hosts:          HostList{host},
+			Connections:    1,
+			ReportInterval: "1s",
+			TotalDuration:  "300ms",
+			Bytes:          "500KB",
+			PassiveClient:  passive,
+			Opt:            Options{ReadSize: 10000, WriteSize: 10000, MaxSpeed: 4},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result, err := client.Run(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("passive=%v: client run: %v", passive, err)
+		}
+
+		wantOutput := int64(size)
+		if passive {
+			wantOutput = 0
+		}
+		c := result.Connections[0]
+		if c.Err != nil {
+			t.Errorf("passive=%v: %v", passive, c.Err)
+		}
+		if c.Input.Bytes != size || c.Output.Bytes != wantOutput {
+			t.Errorf("passive=%v: input=%d output=%d wanted=%d %d", passive, c.Input.Bytes, c.Output.Bytes, size, wantOutput)
+		}
+		if c.Final == nil || c.Final.ReadBytes != wantOutput || c.Final.WriteBytes != size {
+			t.Errorf("passive=%v: final=%v wanted=%d %d", passive, c.Final, wantOutput, size)
+		}
+	}
+}
+
+func TestClientNoServer(t *testing.T) {
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatalf("listen: %v", errListen)
+	}
+	host := listener.Addr().String()
+	listener.Close() // nobody listening
+
+	client := NewClient(&Config{
+		Hosts:          HostList{host},
+		Connections:    1,
+		ReportInterval: "1s",
+		TotalDuration:  "1s",
+		Opt:            testOptions,
+	})
+
+	result, err := client.Run(context.Background())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if len(result.Connections) != 1 || result.Connections[0].Err == nil {
+		t.Errorf("expected failed connection: %v", result.Connections)
+	}
+}
+
+// bytesObserver records reported bytes per stream.
+type bytesObserver struct {
+	NopObserver
+	mutex sync.Mutex
+	bytes map[string]int64
+}
+
+func (o *bytesObserver) StreamDone(s Stream, result StreamResult) {
+	o.mutex.Lock()
+	o.bytes[s.Label] = result.Bytes
+	o.mutex.Unlock()
+}
+
+func TestClientInterrupted(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	obs := &bytesObserver{bytes: map[string]int64{}}
+	client := NewClient(&Config{
+		Hosts:          HostList{host},
+		Connections:    1,
+		ReportInterval: "1s",
+		TotalDuration:  "10s",
+		Opt:            testOptions,
+		Observers:      []Observer{obs},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	result, _ := client.Run(ctx)
+
+	// bytes drained after interrupted reader stopped are reported too
+	c := result.Connections[0]
+	if c.Final == nil {
+		t.Fatalf("missing final counters: %v", c.Err)
+	}
+	if c.Input.Bytes != c.Final.WriteBytes || obs.bytes["clientReader"] != c.Input.Bytes {
+		t.Errorf("received: observer=%d result=%d server sent=%d", obs.bytes["clientReader"], c.Input.Bytes, c.Final.WriteBytes)
+	}
+}
+
+func TestClientRampUp(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	const connections = 20
+
+	client := NewClient(&Config{
+		Hosts:           HostList{host},
+		Connections:     connections,
+		ConnectRate:     100,
+		DialConcurrency: 4,
+		ReportInterval:  "1s",
+		Bytes:           "10KB",
+		Opt:             testOptions,
+	})
+
+	begin := time.Now()
+	result, err := client.Run(context.Background())
+	if err != nil {
+		t.Fatalf("client run: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed < (connections-1)*10*time.Millisecond {
+		t.Errorf("connections opened too fast for ramp-up: %v", elapsed)
+	}
+	if len(result.Connections) != connections || result.Failed() != 0 {
+		t.Errorf("connections=%d failed=%d wanted=%d", len(result.Connections), result.Failed(), connections)
+	}
+	for i, c := range result.Connections {
+		if c.Index != i || c.Proto != "TCP" {
+			t.Errorf("connection %d: index=%d proto=%q", i, c.Index, c.Proto)
+		}
+	}
+}
+
+// endObserver records when every stream ends.
+type endObserver struct {
+	NopObserver
+	mutex sync.Mutex
+	ends  []time.Time
+}
+
+func (o *endObserver) StreamDone(s Stream, result StreamResult) {
+	o.mutex.Lock()
+	o.ends = append(o.ends, time.Now())
+	o.mutex.Unlock()
+}
+
+func TestClientRampUpWindow(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	// ramp-up takes 450ms, longer than test
+	obs := &endObserver{}
+	client := NewClient(&Config{
+		Hosts:          HostList{host},
+		Connections:    10,
+		ConnectRate:    20,
+		ReportInterval: "1s",
+		TotalDuration:  "300ms",
+		Opt:            Options{ReadSize: 10000, WriteSize: 10000, MaxSpeed: 10},
+		Observers:      []Observer{obs},
+	})
+	if _, err := client.Run(context.Background()); err != nil {
+		t.Fatalf("client run: %v", err)
+	}
+
+	if len(obs.ends) != 20 {
+		t.Fatalf("streams=%d wanted=20", len(obs.ends))
+	}
+	first, last := obs.ends[0], obs.ends[0]
+	for _, end := range obs.ends {
+		if end.Before(first) {
+			first = end
+		}
+		if end.After(last) {
+			last = end
+		}
+	}
+	if spread := last.Sub(first); spread > 200*time.Millisecond {
+		t.Errorf("streams ended %v apart, test window not common to connections", spread)
+	}
+}
+
+func TestClientFailures(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	// server that quits right after ack
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatalf("listen: %v", errListen)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			var opt Options
+			if gob.NewDecoder(exactReader{conn}).Decode(&opt) == nil {
+				ackSend(false, conn, newAck())
+			}
+			conn.Close()
+		}
+	}()
+
+	client := NewClient(&Config{
+		Hosts:          HostList{host, "127.0.0.1:1", listener.Addr().String()},
+		Connections:    1,
+		ReportInterval: "1s",
+		TotalDuration:  "1s",
+		DialTimeout:    "1s",
+		Opt:            testOptions,
+	})
+
+	result, err := client.Run(context.Background())
+	if err != nil {
+		t.Fatalf("client run: %v", err)
+	}
+	if failed := result.Failed(); failed != 2 {
+		t.Errorf("failed=%d wanted=2", failed)
+	}
+	if c := result.Connections[0]; c.Err != nil {
+		t.Errorf("%s: unexpected error: %v", c.Host, c.Err)
+	}
+	if c := result.Connections[2]; c.Err == nil || !strings.Contains(c.Err.Error(), "ended early") {
+		t.Errorf("%s: expected ended early: %v", c.Host, c.Err)
+	}
+}
+
+func TestClientDialRetries(t *testing.T) {
+	// find free port, then start server on it only after client first attempt
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatalf("listen: %v", errListen)
+	}
+	host := listener.Addr().String()
+	listener.Close()
+
+	server := NewServer(&Config{
+		Listeners:      HostList{host},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+	time.AfterFunc(dialRetryDelay/2, func() {
+		if err := server.Start(context.Background()); err != nil {
+			t.Errorf("server start: %v", err)
+		}
+	})
+
+	client := NewClient(&Config{
+		Hosts:          HostList{host},
+		Connections:    1,
+		ReportInterval: "1s",
+		Bytes:          "100KB",
+		DialRetries:    2,
+		Opt:            testOptions,
+	})
+
+	result, err := client.Run(context.Background())
+	if err != nil || result.Failed() != 0 {
+		t.Errorf("expected retry to succeed: %v", err)
+	}
+}
+
+func TestClientDialSlotRetry(t *testing.T) {
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatalf("listen: %v", errListen)
+	}
+	defer listener.Close()
+	accepted := make(chan time.Time, 1)
+	go func() {
+		conn, errAccept := listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		accepted <- time.Now()
+		conn.Close()
+	}()
+
+	// first host refuses and is retried, its delay must not hold the
+	// single dial slot
+	client := NewClient(&Config{
+		Hosts:          HostList{"127.0.0.1:1", listener.Addr().String()},
+		Connections:    1,
+		ReportInterval: "1s",
+		TotalDuration:  "1s",
+		DialRetries:    1,
+		Opt:            testOptions,
+	})
+
+	begin := time.Now()
+	client.Run(context.Background()) // both hosts fail
+
+	select {
+	case at := <-accepted:
+		if waited := at.Sub(begin); waited >= dialRetryDelay {
+			t.Errorf("second host dialed after %v, slot held during retry delay", waited)
+		}
+	default:
+		t.Errorf("second host not dialed")
+	}
+}
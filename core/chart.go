@@ -1,7 +1,7 @@
 package core
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/wcharczuk/go-chart"
@@ -9,8 +9,7 @@ import (
 
 func chartRender(filename string, input *ChartData, output *ChartData) error {
 
-	log.Printf("chartRender: input data points:  %d/%d", len(input.XValues), len(input.YValues))
-	log.Printf("chartRender: output data points: %d/%d", len(output.XValues), len(output.YValues))
+	slog.Debug("chartRender: data points", "input", len(input.YValues), "output", len(output.YValues))
 
 	out, errCreate := os.Create(filename)
 	if errCreate != nil {
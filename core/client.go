@@ -2,19 +2,22 @@ package core
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func Open(app *Config) {
+// open runs client test until completion or ctx cancellation.
+func open(ctx context.Context, app *Config) *Result {
 
 	var proto string
 	if app.Udp {
@@ -25,85 +28,190 @@ func Open(app *Config) {
 
 	var wg sync.WaitGroup
 
+	result := &Result{}
+	obs := app.observer()
+
 	var aggReader aggregate
 	var aggWriter aggregate
 
-	dialer := net.Dialer{}
+	if app.Opt.Verify && app.Opt.VerifySeed == 0 {
+		app.Opt.VerifySeed = time.Now().UnixNano()
+		slog.Info("open: payload verification seed", "seed", app.Opt.VerifySeed)
+	}
+
+	dialer := net.Dialer{Timeout: app.dialTimeout}
 
 	if app.LocalAddr != "" {
 		if app.Udp {
 			addr, err := net.ResolveUDPAddr(proto, app.LocalAddr)
 			if err != nil {
-				log.Printf("open: resolve %s localAddr=%s: %v", proto, app.LocalAddr, err)
+				slog.Error("open: resolve localAddr", "proto", proto, "localAddr", app.LocalAddr, "err", err)
 			}
 			dialer.LocalAddr = addr
 		} else {
 			addr, err := net.ResolveTCPAddr(proto, app.LocalAddr)
 			if err != nil {
-				log.Printf("open: resolve %s localAddr=%s: %v", proto, app.LocalAddr, err)
+				slog.Error("open: resolve localAddr", "proto", proto, "localAddr", app.LocalAddr, "err", err)
 			}
 			dialer.LocalAddr = addr
 		}
-		log.Printf("open: localAddr: %s", dialer.LocalAddr)
+		slog.Info("open: localAddr", "localAddr", dialer.LocalAddr)
 	}
 
+	// ramp-up paces connection establishment, sparing server accept queue
+	var pace <-chan time.Time
+	if app.ConnectRate > 0 {
+		if period := time.Duration(float64(time.Second) / app.ConnectRate); period > 0 {
+			ticker := time.NewTicker(period)
+			defer ticker.Stop()
+			pace = ticker.C
+		}
+	}
+	dialSlots := make(chan struct{}, app.DialConcurrency)
+	start := make(chan struct{}) // closed once every connection is established
+	var wgDial sync.WaitGroup
+	var established, failed atomic.Int64
+	rampStart := time.Now()
+
+HOSTS:
 	for _, h := range app.Hosts {
 
 		hh := appendPortIfMissing(h, app.DefaultPort)
 
 		for i := 0; i < app.Connections; i++ {
 
-			log.Printf("open: opening TLS=%v %s %d/%d: %s", app.Tls, proto, i, app.Connections, hh)
-
-			if !app.Udp && app.Tls {
-				// try TLS first
-				log.Printf("open: trying TLS")
-				conn, errDialTLS := tlsDial(dialer, proto, hh)
-				if errDialTLS == nil {
-					spawnClient(app, &wg, conn, i, app.Connections, true, &aggReader, &aggWriter)
-					continue
+			if pace != nil && len(result.Connections) > 0 {
+				select {
+				case <-pace:
+				case <-ctx.Done():
 				}
-				log.Printf("open: trying TLS: failure: %s: %s: %v", proto, hh, errDialTLS)
 			}
 
-			if !app.Udp {
-				log.Printf("open: trying non-TLS TCP")
+			select {
+			case dialSlots <- struct{}{}:
+			case <-ctx.Done():
 			}
 
-			conn, errDial := dialer.Dial(proto, hh)
-			if errDial != nil {
-				log.Printf("open: dial %s: %s: %v", proto, hh, errDial)
-				continue
+			if ctx.Err() != nil {
+				slog.Info("open: interrupted", "err", ctx.Err())
+				break HOSTS
 			}
-			spawnClient(app, &wg, conn, i, app.Connections, false, &aggReader, &aggWriter)
+
+			cr := &ConnectionResult{Host: hh, Index: i}
+			result.Connections = append(result.Connections, cr)
+
+			wgDial.Add(1)
+			go func(i int) {
+				defer wgDial.Done()
+				conn := dial(ctx, app, dialer, proto, hh, i, cr, dialSlots)
+				if conn == nil {
+					failed.Add(1)
+					return
+				}
+				established.Add(1)
+				spawnClient(ctx, app, &wg, conn, cr, app.Connections, start, &aggReader, &aggWriter, obs)
+			}(i)
 		}
 	}
 
+	wgDial.Wait()
+
+	logResult("open", "established", established.Load(), "failed", failed.Load(), "elapsed", time.Since(rampStart))
+
+	// test window is common to all connections, regardless of ramp-up
+	close(start)
+
 	wg.Wait()
 
-	log.Printf("aggregate reading: %d Mbps %d recv/s", aggReader.Mbps, aggReader.Cps)
-	log.Printf("aggregate writing: %d Mbps %d send/s", aggWriter.Mbps, aggWriter.Cps)
+	result.Reading = Aggregate{Mbps: aggReader.Mbps, Cps: aggReader.Cps}
+	result.Writing = Aggregate{Mbps: aggWriter.Mbps, Cps: aggWriter.Cps}
+
+	obs.TestDone(result)
+
+	return result
 }
 
-func spawnClient(app *Config, wg *sync.WaitGroup, conn net.Conn, c, connections int, isTLS bool, aggReader, aggWriter *aggregate) {
-	wg.Add(1)
-	go handleConnectionClient(app, wg, conn, c, connections, isTLS, aggReader, aggWriter)
+// dialRetryDelay is the pause before retrying a failed connection.
+const dialRetryDelay = time.Second
+
+// dial connects to hh, retrying up to Config.DialRetries times. It is
+// called holding one of slots, which is released during each attempt's
+// retry delay and before returning. Rejected server certificates are
+// not retried. It returns nil on failure recorded into cr.
+func dial(ctx context.Context, app *Config, dialer net.Dialer, proto, hh string, i int, cr *ConnectionResult, slots chan struct{}) net.Conn {
+	for attempt := 1; ; attempt++ {
+		conn := dialOnce(ctx, app, dialer, proto, hh, i, cr)
+		<-slots
+		if conn != nil || attempt > app.DialRetries || tlsRejected(cr.Err) {
+			return conn
+		}
+		slog.Warn("open: retrying", "conn", fmt.Sprintf("%d/%d", i, app.Connections), "host", hh, "attempt", attempt, "err", cr.Err)
+		select {
+		case <-time.After(dialRetryDelay):
+		case <-ctx.Done():
+			return nil
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		cr.Err = nil
+	}
 }
 
-func tlsDial(dialer net.Dialer, proto, h string) (net.Conn, error) {
-	conf := &tls.Config{
-		InsecureSkipVerify: true,
+// dialOnce connects to hh, returning nil on failure recorded into cr.
+func dialOnce(ctx context.Context, app *Config, dialer net.Dialer, proto, hh string, i int, cr *ConnectionResult) net.Conn {
+	slog.Info("open: opening", "tls", app.TlsMode, "proto", proto, "conn", fmt.Sprintf("%d/%d", i, app.Connections), "host", hh)
+
+	if !app.Udp && app.Tls {
+		// try TLS first
+		slog.Debug("open: trying TLS")
+		conn, handshake, errDialTLS := tlsDial(ctx, app, dialer, proto, hh)
+		if errDialTLS == nil {
+			cr.TLS = true
+			cr.Proto = protoLabel(true)
+			cr.TLSInfo = newTLSInfo(conn.ConnectionState(), handshake)
+			logTLS(cr.TLSInfo, "conn", fmt.Sprintf("%d/%d", i, app.Connections), "remote", conn.RemoteAddr())
+			return conn
+		}
+		if app.tlsStrict() {
+			// never measure unverified plain TCP in place of verified TLS
+			slog.Error("open: TLS", "host", hh, "err", errDialTLS)
+			cr.Err = errDialTLS
+			return nil
+		}
+		slog.Warn("open: trying TLS: failure, falling back to plain TCP (use -tls=required to prevent)", "proto", proto, "host", hh, "err", errDialTLS)
+	}
+
+	if !app.Udp {
+		slog.Debug("open: trying non-TLS TCP")
 	}
 
-	conn, err := tls.DialWithDialer(&dialer, proto, h, conf)
+	conn, errDial := dialer.DialContext(ctx, proto, hh)
+	if errDial != nil {
+		slog.Error("open: dial", "proto", proto, "host", hh, "err", errDial)
+		cr.Err = errDial
+		return nil
+	}
+	cr.Proto = connProto(conn)
+	return conn
+}
 
-	return conn, err
+func spawnClient(ctx context.Context, app *Config, wg *sync.WaitGroup, conn net.Conn, cr *ConnectionResult, connections int, start <-chan struct{}, aggReader, aggWriter *aggregate, obs Observer) {
+	wg.Add(1)
+	go handleConnectionClient(ctx, app, wg, conn, cr, connections, start, aggReader, aggWriter, obs)
 }
 
 // ExportInfo records data for export
 type ExportInfo struct {
 	Input  ChartData
 	Output ChartData
+	Proto  string   `yaml:",omitempty"` // TCP, TLS or UDP
+	TLS    *TLSInfo `yaml:",omitempty"`
+
+	InputCorrupted  int64 `yaml:",omitempty"` // verification: corrupted bytes received
+	OutputCorrupted int64 `yaml:",omitempty"` // verification: corrupted bytes received by server
 }
 
 func sendOptions(app *Config, conn io.Writer) error {
@@ -112,158 +220,355 @@ func sendOptions(app *Config, conn io.Writer) error {
 		var optBuf bytes.Buffer
 		enc := gob.NewEncoder(&optBuf)
 		if errOpt := enc.Encode(&opt); errOpt != nil {
-			log.Printf("handleConnectionClient: UDP Options failure: %v", errOpt)
+			slog.Error("handleConnectionClient: UDP options encoding", "err", errOpt)
 			return errOpt
 		}
 		_, optWriteErr := conn.Write(optBuf.Bytes())
 		if optWriteErr != nil {
-			log.Printf("handleConnectionClient: UDP Options write: %v", optWriteErr)
+			slog.Error("handleConnectionClient: UDP options write", "err", optWriteErr)
 			return optWriteErr
 		}
 	} else {
 		enc := gob.NewEncoder(conn)
 		if errOpt := enc.Encode(&opt); errOpt != nil {
-			log.Printf("handleConnectionClient: TCP Options failure: %v", errOpt)
+			slog.Error("handleConnectionClient: TCP options failure", "err", errOpt)
 			return errOpt
 		}
 	}
 	return nil
 }
 
-func handleConnectionClient(app *Config, wg *sync.WaitGroup, conn net.Conn, c, connections int, isTLS bool, aggReader, aggWriter *aggregate) {
+func handleConnectionClient(ctx context.Context, app *Config, wg *sync.WaitGroup, conn net.Conn, cr *ConnectionResult, connections int, start <-chan struct{}, aggReader, aggWriter *aggregate, obs Observer) {
 	defer wg.Done()
 
-	log.Printf("handleConnectionClient: starting %s %d/%d %v", protoLabel(isTLS), c, connections, conn.RemoteAddr())
+	c := cr.Index
+	isTLS := cr.TLS
+	cr.Remote = conn.RemoteAddr().String()
+
+	// server starts test on options, hence they are sent only when
+	// every connection is established
+	select {
+	case <-start:
+	case <-ctx.Done():
+		cr.Err = ctx.Err()
+		conn.Close()
+		return
+	}
+
+	slog.Debug("handleConnectionClient: starting", "proto", protoLabel(isTLS), "conn", fmt.Sprintf("%d/%d", c, connections), "remote", conn.RemoteAddr())
 
 	// send Options
 	if errOpt := sendOptions(app, conn); errOpt != nil {
+		cr.Err = errOpt
+		conn.Close()
 		return
 	}
 	opt := app.Opt
-	log.Printf("handleConnectionClient: Options sent: %v", opt)
+	slog.Debug("handleConnectionClient: options sent", "options", opt)
 
 	// receive ack
 	//log.Printf("handleConnectionClient: FIXME WRITEME server does not send ack for UDP")
 	if !app.Udp {
 		var a ack
 		if errAck := ackRecv(app.Udp, conn, &a); errAck != nil {
-			log.Printf("handleConnectionClient: receiving ack: %v", errAck)
+			slog.Error("handleConnectionClient: receiving ack", "err", errAck)
+			cr.Err = errAck
+			conn.Close()
 			return
 		}
-		log.Printf("handleConnectionClient: %s ack received", protoLabel(isTLS))
+		slog.Debug("handleConnectionClient: ack received", "proto", protoLabel(isTLS))
 	}
 
-	doneReader := make(chan struct{})
-	doneWriter := make(chan struct{})
+	rs := newStreamState()
+	ws := newStreamState()
 
-	info := ExportInfo{
-		Input:  ChartData{},
-		Output: ChartData{},
+	var reader io.Reader = conn
+	var tr *trailerReader
+	if !app.Udp {
+		tr = newTrailerReader(conn) // server sends final counters after payload
+		reader = tr
 	}
 
-	var input *ChartData
-	var output *ChartData
+	// writer is stopped first, reader keeps receiving until server finishes
+	ctxReader, cancelReader := context.WithCancel(ctx)
+	defer cancelReader()
+	ctxWriter, cancelWriter := context.WithCancel(ctx)
+	defer cancelWriter()
 
-	if app.Csv != "" || app.Export != "" || app.Chart != "" || app.Ascii {
-		input = &info.Input
-		output = &info.Output
+	go clientReader(ctxReader, conn, reader, c, connections, rs, opt, aggReader, obs)
+	if app.PassiveClient {
+		ws.finish(StreamResult{})
+	} else {
+		go clientWriter(ctxWriter, conn, c, connections, ws, opt, app.SendFile, aggWriter, obs)
 	}
 
-	go clientReader(conn, c, connections, doneReader, opt, input, aggReader)
-	if !app.PassiveClient {
-		go clientWriter(conn, c, connections, doneWriter, opt, output, aggWriter)
+	// size-limited TCP test runs both streams to completion, as server
+	// does. otherwise client stops sending on timer or when its writer
+	// is done, then keeps receiving until server finishes. passive
+	// client relies only on timer.
+	limited := !app.Udp && opt.limit() > 0
+	if !limited {
+		writerDone := ws.done
+		if app.PassiveClient {
+			writerDone = nil
+		}
+		waitEnd(ctx, opt.TotalDuration, "handleConnectionClient", rs.done, writerDone)
 	}
 
-	tickerPeriod := time.NewTimer(app.Opt.TotalDuration)
+	connIndex := fmt.Sprintf("%d/%d", c, connections)
 
-	<-tickerPeriod.C
-	log.Printf("handleConnectionClient: %v timer", app.Opt.TotalDuration)
+	if app.Udp {
+		cancelReader()
+		cancelWriter()
+		conn.SetReadDeadline(time.Now()) // wake blocked reader
+		<-rs.done
+		<-ws.done
+	} else {
+		cr.Final = finishClient(ctx, conn, tr, connIndex, rs, ws, cancelWriter, limited)
+	}
 
-	tickerPeriod.Stop()
+	conn.Close()
 
-	conn.Close() // force reader/writer to quit
+	// received bytes include those drained after reader stopped
+	rs.report(obs)
+	ws.report(obs)
 
-	<-doneReader // wait reader exit
-	if !app.PassiveClient {
-		<-doneWriter // wait writer exit
-	}
+	cr.Input = rs.result
+	cr.Output = ws.result
 
-	if app.Csv != "" {
-		filename := fmt.Sprintf(app.Csv, c, conn.RemoteAddr())
-		log.Printf("exporting CSV test results to: %s", filename)
-		errExport := ExportCsv(filename, &info)
-		if errExport != nil {
-			log.Printf("handleConnectionClient: export CSV: %s: %v", filename, errExport)
+	if ctx.Err() == nil {
+		switch {
+		case ws.result.Err != nil:
+			cr.Err = fmt.Errorf("ended early: sending: %v", ws.result.Err)
+		case rs.result.Err != nil:
+			cr.Err = fmt.Errorf("ended early: receiving: %v", rs.result.Err)
+		case !app.Udp && cr.Final == nil:
+			cr.Err = errors.New("ended early: missing server final counters")
 		}
 	}
-
-	if app.Export != "" {
-		filename := fmt.Sprintf(app.Export, c, conn.RemoteAddr())
-		log.Printf("exporting YAML test results to: %s", filename)
-		errExport := export(filename, &info)
-		if errExport != nil {
-			log.Printf("handleConnectionClient: export YAML: %s: %v", filename, errExport)
+	if cr.Err == nil {
+		switch {
+		case cr.Input.Corrupted > 0:
+			cr.Err = fmt.Errorf("payload corrupted: receiving: %d bytes, first at offset %d", cr.Input.Corrupted, cr.Input.CorruptedOffset)
+		case cr.Output.Corrupted > 0:
+			cr.Err = fmt.Errorf("payload corrupted: sending: %d bytes, first at offset %d", cr.Output.Corrupted, cr.Output.CorruptedOffset)
 		}
 	}
 
-	if app.Chart != "" {
-		filename := fmt.Sprintf(app.Chart, c, conn.RemoteAddr())
-		log.Printf("rendering chart to: %s", filename)
-		errRender := chartRender(filename, &info.Input, &info.Output)
-		if errRender != nil {
-			log.Printf("handleConnectionClient: render PNG: %s: %v", filename, errRender)
+	obs.ConnectionDone(cr)
+
+	slog.Info("handleConnectionClient: closing", "conn", fmt.Sprintf("%d/%d", c, connections), "remote", conn.RemoteAddr())
+}
+
+// waitEnd blocks until either stream is done, the total duration expires
+// or ctx is cancelled. Zero duration means no time limit. Nil channel is
+// not waited for.
+func waitEnd(ctx context.Context, duration time.Duration, label string, doneReader, doneWriter <-chan struct{}) {
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-timeout:
+		slog.Info(label+": timer", "duration", duration)
+	case <-ctx.Done():
+		slog.Info(label+": interrupted", "err", ctx.Err())
+	case <-doneReader:
+	case <-doneWriter:
+	}
+}
+
+// finishClient performs client side of the end of test protocol,
+// returning server final counters or nil if they were not received.
+// Size-limited streams are neither stopped nor given a read deadline,
+// unless ctx is done.
+func finishClient(ctx context.Context, conn net.Conn, tr *trailerReader, connIndex string, rs, ws *streamState, stopWriter context.CancelFunc, limited bool) *FinalCounters {
+	if !limited {
+		stopWriter()
+	}
+	<-ws.done
+	closeWrite(conn) // server reader sees EOF
+
+	if limited {
+		select {
+		case <-rs.done:
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now().Add(stopGrace))
+			<-rs.done
 		}
+	} else {
+		conn.SetReadDeadline(time.Now().Add(stopGrace))
+		<-rs.done
+	}
+
+	// reader might have stopped early on transfer limit or interruption
+	extra, errDrain := drain(tr)
+	if errDrain != nil {
+		slog.Error("handleConnectionClient: draining", "conn", connIndex, "err", errDrain)
+		return nil
 	}
 
-	plotascii(&info, conn.RemoteAddr().String(), c)
+	fin, errFinal := decodeFinal(tr.trailer)
+	if errFinal != nil {
+		slog.Error("handleConnectionClient: final counters", "conn", connIndex, "err", errFinal)
+		return nil
+	}
+
+	rs.result.Bytes += extra
+	ws.result.Corrupted = fin.ReadCorrupted // upload verified by server
+	ws.result.CorruptedOffset = fin.ReadCorruptedOffset
+	received := rs.result.Bytes
+	logResult("final", "conn", connIndex, "clientSent", ws.result.Bytes, "serverReceived", fin.ReadBytes,
+		"serverSent", fin.WriteBytes, "clientReceived", received)
+	if ws.result.Bytes != fin.ReadBytes || fin.WriteBytes != received {
+		slog.Warn("final: MISMATCH between sent and received counters", "conn", connIndex)
+	}
 
-	log.Printf("handleConnectionClient: closing: %d/%d %v", c, connections, conn.RemoteAddr())
+	return &fin
 }
 
-func clientReader(conn net.Conn, c, connections int, done chan struct{}, opt Options, stat *ChartData, agg *aggregate) {
-	log.Printf("clientReader: starting: %d/%d %v", c, connections, conn.RemoteAddr())
+func clientReader(ctx context.Context, conn net.Conn, r io.Reader, c, connections int, rs *streamState, opt Options, agg *aggregate, obs Observer) {
+	slog.Debug("clientReader: starting", "conn", fmt.Sprintf("%d/%d", c, connections), "remote", conn.RemoteAddr())
 
 	connIndex := fmt.Sprintf("%d/%d", c, connections)
 
 	buf := make([]byte, opt.ReadSize)
 
-	workLoop(connIndex, "clientReader", "rcv/s", conn.Read, buf, opt.ReportInterval, 0, stat, agg)
+	read := r.Read
+	var verify *verifyReader
+	if opt.Verify {
+		verify = newVerifyReader(r, opt, isDatagram(conn))
+		read = verify.read
+	}
+	if !isDatagram(conn) {
+		read = limitCall(read, opt.limit())
+	}
+
+	s := Stream{Conn: connIndex, Remote: conn.RemoteAddr().String(), Proto: connProto(conn), Label: "clientReader", Input: true}
+	result := workLoop(ctx, s, read, buf, opt.ReportInterval, opt.Omit, 0, nil, agg, obs)
 
-	close(done)
+	if verify != nil {
+		verify.report(connIndex, "clientReader")
+		verify.record(&result)
+	}
+
+	rs.stream = &s
+	rs.finish(result)
 
-	log.Printf("clientReader: exiting: %d/%d %v", c, connections, conn.RemoteAddr())
+	slog.Debug("clientReader: exiting", "conn", fmt.Sprintf("%d/%d", c, connections), "remote", conn.RemoteAddr())
 }
 
-func clientWriter(conn net.Conn, c, connections int, done chan struct{}, opt Options, stat *ChartData, agg *aggregate) {
-	log.Printf("clientWriter: starting: %d/%d %v", c, connections, conn.RemoteAddr())
+func clientWriter(ctx context.Context, conn net.Conn, c, connections int, ws *streamState, opt Options, sendFile string, agg *aggregate, obs Observer) {
+	var result StreamResult
+	defer func() { ws.finish(result) }()
+
+	slog.Debug("clientWriter: starting", "conn", fmt.Sprintf("%d/%d", c, connections), "remote", conn.RemoteAddr())
 
 	connIndex := fmt.Sprintf("%d/%d", c, connections)
 
 	buf := randBuf(opt.WriteSize)
 
-	workLoop(connIndex, "clientWriter", "snd/s", conn.Write, buf, opt.ReportInterval, opt.MaxSpeed, stat, agg)
+	write := conn.Write
+	if opt.Verify {
+		write = newVerifyWriter(conn, opt, isDatagram(conn)).write
+	}
+
+	var file io.ReadCloser
+	var fs fileStats
+	if sendFile != "" {
+		var errOpen error
+		file, errOpen = openSendFile(sendFile)
+		if errOpen != nil {
+			slog.Error("clientWriter: open", "conn", connIndex, "err", errOpen)
+			return
+		}
+		defer file.Close()
+		write = fileSender(file, conn, &fs)
+	}
+
+	write = limitCall(write, opt.limit())
 
-	close(done)
+	s := Stream{Conn: connIndex, Remote: conn.RemoteAddr().String(), Proto: connProto(conn), Label: "clientWriter"}
+	ws.stream = &s
+	result = workLoop(ctx, s, write, buf, opt.ReportInterval, opt.Omit, opt.MaxSpeed, retransCounter(conn), agg, obs)
 
-	log.Printf("clientWriter: exiting: %d/%d %v", c, connections, conn.RemoteAddr())
+	if file != nil {
+		fs.report(connIndex, "clientWriter", sendFile)
+	}
+
+	slog.Debug("clientWriter: exiting", "conn", fmt.Sprintf("%d/%d", c, connections), "remote", conn.RemoteAddr())
 }
 
 func randBuf(size int) []byte {
 	buf := make([]byte, size)
 	if _, err := rand.Read(buf); err != nil {
-		log.Printf("randBuf error: %v", err)
+		slog.Error("randBuf", "err", err)
 	}
 	return buf
 }
 
 type call func(p []byte) (n int, err error)
 
+// Read lets call be used as io.Reader.
+func (f call) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+var errLimitReached = errors.New("transfer limit reached")
+
+// errDurationReached ends a stream normally on its own duration timer.
+var errDurationReached = errors.New("total duration reached")
+
+// limitCall stops f after limit bytes, returning errLimitReached.
+// Zero limit means unlimited.
+func limitCall(f call, limit int64) call {
+	if limit < 1 {
+		return f
+	}
+	var total int64
+	return func(p []byte) (int, error) {
+		remain := limit - total
+		if remain < 1 {
+			return 0, errLimitReached
+		}
+		if int64(len(p)) > remain {
+			p = p[:remain]
+		}
+		n, err := f(p)
+		total += int64(n)
+		return n, err
+	}
+}
+
 type account struct {
+	stream    Stream
+	obs       Observer
 	prevTime  time.Time
 	prevSize  int64
 	prevCalls int
 	size      int64
 	calls     int
+	chart     ChartData
+	retrans   func() int64 // retransmitted segments on connection, nil if unavailable
+
+	// averages are computed from base, which moves past the omitted period
+	omitUntil time.Time
+	baseTime  time.Time
+	baseSize  int64
+	baseCalls int
+}
+
+func newAccount(s Stream, obs Observer, start time.Time, omit time.Duration) *account {
+	return &account{
+		stream:    s,
+		obs:       obs,
+		prevTime:  start,
+		omitUntil: start.Add(omit),
+		baseTime:  start,
+	}
 }
 
 // ChartData records data for chart
@@ -272,28 +577,43 @@ type ChartData struct {
 	YValues []float64
 }
 
-const fmtReport = "%s %7s %14s rate: %6d Mbps %6d %s"
+func (a *account) update(n int, reportInterval time.Duration) {
+	a.updateAt(n, reportInterval, time.Now())
+}
 
-func (a *account) update(n int, reportInterval time.Duration, conn, label, cpsLabel string, stat *ChartData) {
+func (a *account) updateAt(n int, reportInterval time.Duration, now time.Time) {
 	a.calls++
 	a.size += int64(n)
 
-	now := time.Now()
 	elap := now.Sub(a.prevTime)
 	if elap > reportInterval {
 		elapSec := elap.Seconds()
-		mbps := float64(8*(a.size-a.prevSize)) / (1000000 * elapSec)
-		cps := int64(float64(a.calls-a.prevCalls) / elapSec)
-		log.Printf(fmtReport, conn, "report", label, int64(mbps), cps, cpsLabel)
+		sample := Sample{
+			Time:    now,
+			Bytes:   a.size - a.prevSize,
+			Mbps:    float64(8*(a.size-a.prevSize)) / (1000000 * elapSec),
+			Cps:     int64(float64(a.calls-a.prevCalls) / elapSec),
+			Omitted: a.prevTime.Before(a.omitUntil),
+		}
+		if a.retrans != nil {
+			sample.Retransmits = a.retrans()
+		}
+		if sample.Omitted {
+			a.baseTime = now
+			a.baseSize = a.size
+			a.baseCalls = a.calls
+		}
 		a.prevTime = now
 		a.prevSize = a.size
 		a.prevCalls = a.calls
 
 		// save chart data
-		if stat != nil {
-			stat.XValues = append(stat.XValues, now)
-			stat.YValues = append(stat.YValues, mbps)
+		if !sample.Omitted {
+			a.chart.XValues = append(a.chart.XValues, now)
+			a.chart.YValues = append(a.chart.YValues, sample.Mbps)
 		}
+
+		a.obs.Sample(a.stream, sample)
 	}
 }
 
@@ -303,25 +623,48 @@ type aggregate struct {
 	mutex sync.Mutex
 }
 
-func (a *account) average(start time.Time, conn, label, cpsLabel string, agg *aggregate) {
-	elapSec := time.Since(start).Seconds()
-	mbps := int64(float64(8*a.size) / (1000000 * elapSec))
-	cps := int64(float64(a.calls) / elapSec)
-	log.Printf(fmtReport, conn, "average", label, mbps, cps, cpsLabel)
+// average computes stream result and adds its rates to agg.
+func (a *account) average(start time.Time, agg *aggregate) StreamResult {
+	return a.averageAt(start, time.Now(), agg)
+}
+
+func (a *account) averageAt(start, now time.Time, agg *aggregate) StreamResult {
+	baseTime, baseSize, baseCalls := a.baseTime, a.baseSize, a.baseCalls
+	if now.Before(a.omitUntil) {
+		// stream ended within omitted period, nothing left to omit
+		baseTime, baseSize, baseCalls = start, 0, 0
+	}
+	var mbps, cps int64
+	if elapSec := now.Sub(baseTime).Seconds(); elapSec > 0 {
+		mbps = int64(float64(8*(a.size-baseSize)) / (1000000 * elapSec))
+		cps = int64(float64(a.calls-baseCalls) / elapSec)
+	}
+	result := StreamResult{
+		Bytes:    a.size,
+		Mbps:     mbps,
+		Cps:      cps,
+		Duration: now.Sub(start),
+		Chart:    a.chart,
+	}
 
 	agg.mutex.Lock()
-	agg.Mbps += mbps
-	agg.Cps += cps
+	agg.Mbps += result.Mbps
+	agg.Cps += result.Cps
 	agg.mutex.Unlock()
+
+	return result
 }
 
-func workLoop(conn, label, cpsLabel string, f call, buf []byte, reportInterval time.Duration, maxSpeed float64, stat *ChartData, agg *aggregate) {
+// workLoop runs until f fails or ctx is cancelled, returning stream result.
+// Caller reports the result to observers.
+func workLoop(ctx context.Context, s Stream, f call, buf []byte, reportInterval, omit time.Duration, maxSpeed float64, retrans func() int64, agg *aggregate, obs Observer) StreamResult {
 
 	start := time.Now()
-	acc := &account{}
-	acc.prevTime = start
+	acc := newAccount(s, obs, start, omit)
+	acc.retrans = retrans
 
-	for {
+	var errStream error
+	for ctx.Err() == nil {
 		runtime.Gosched()
 
 		if maxSpeed > 0 {
@@ -336,13 +679,26 @@ func workLoop(conn, label, cpsLabel string, f call, buf []byte, reportInterval t
 		}
 
 		n, errCall := f(buf)
+		if errCall == errLimitReached || errCall == errDurationReached || errCall == io.EOF {
+			if n > 0 {
+				acc.update(n, reportInterval)
+			}
+			logResult("complete", "conn", s.Conn, "stream", s.Label, "bytes", acc.size, "elapsed", time.Since(start))
+			break
+		}
+		if errCall != nil && ctx.Err() != nil {
+			break // interrupted call is not an error
+		}
 		if errCall != nil {
-			log.Printf("workLoop: %s %s: %v", conn, label, errCall)
+			slog.Error("workLoop", "conn", s.Conn, "stream", s.Label, "err", errCall)
+			errStream = errCall
 			break
 		}
 
-		acc.update(n, reportInterval, conn, label, cpsLabel, stat)
+		acc.update(n, reportInterval)
 	}
 
-	acc.average(start, conn, label, cpsLabel, agg)
+	result := acc.average(start, agg)
+	result.Err = errStream
+	return result
 }
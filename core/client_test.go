@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+type sampleObserver struct {
+	NopObserver
+	samples []Sample
+}
+
+func (o *sampleObserver) Sample(s Stream, sample Sample) {
+	o.samples = append(o.samples, sample)
+}
+
+func TestAccountOmit(t *testing.T) {
+	start := time.Now()
+	at := func(sec float64) time.Time {
+		return start.Add(time.Duration(sec * float64(time.Second)))
+	}
+
+	obs := &sampleObserver{}
+	acc := newAccount(Stream{}, obs, start, 2*time.Second)
+	for _, sec := range []float64{1.5, 2.6, 3.7, 4.8} {
+		acc.updateAt(1000000, time.Second, at(sec))
+	}
+	result := acc.averageAt(start, at(6.6), &aggregate{})
+
+	// samples starting before omit period ends are omitted
+	wantOmitted := []bool{true, true, false, false}
+	if len(obs.samples) != len(wantOmitted) {
+		t.Fatalf("samples=%d wanted=%d", len(obs.samples), len(wantOmitted))
+	}
+	for i, sample := range obs.samples {
+		if sample.Omitted != wantOmitted[i] {
+			t.Errorf("sample %d: omitted=%v wanted=%v", i, sample.Omitted, wantOmitted[i])
+		}
+	}
+	if len(result.Chart.YValues) != 2 {
+		t.Errorf("chart points=%d wanted=2", len(result.Chart.YValues))
+	}
+
+	// bytes count everything, average excludes omitted period: 2MB in 4s
+	if result.Bytes != 4000000 {
+		t.Errorf("bytes=%d wanted=4000000", result.Bytes)
+	}
+	if result.Mbps != 4 {
+		t.Errorf("mbps=%d wanted=4", result.Mbps)
+	}
+
+	// stream ending within omitted period is averaged as a whole: 1MB in 2s
+	acc = newAccount(Stream{}, obs, start, 10*time.Second)
+	acc.updateAt(1000000, time.Second, at(1.5))
+	if result := acc.averageAt(start, at(2), &aggregate{}); result.Mbps != 4 {
+		t.Errorf("ended within omit: mbps=%d wanted=4", result.Mbps)
+	}
+}
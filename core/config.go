@@ -1,7 +1,11 @@
 package core
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -9,36 +13,339 @@ import (
 
 const Version = "0.4"
 
+// defaultTotalDuration applies when neither duration nor size limit is given.
+const defaultTotalDuration = "10s"
+
+// defaultDialTimeout bounds connection attempts when DialTimeout is empty.
+const defaultDialTimeout = "10s"
+
 type HostList []string
 
 type Config struct {
-	Hosts          HostList
-	Listeners      HostList
-	DefaultPort    string
-	Connections    int
-	ReportInterval string
-	TotalDuration  string
-	Opt            Options
-	PassiveClient  bool // suppress client send
-	Udp            bool
-	Chart          string
-	Export         string
-	Csv            string
-	Ascii          bool // plot ascii chart
-	TlsCert        string
-	TlsKey         string
-	Tls            bool
-	LocalAddr      string
+	Hosts           HostList
+	Listeners       HostList
+	DefaultPort     string
+	Connections     int
+	ReportInterval  string
+	TotalDuration   string
+	Bytes           string
+	Omit            string
+	Opt             Options
+	PassiveClient   bool // suppress client send
+	Udp             bool
+	Chart           string
+	Export          string
+	Csv             string
+	Ascii           bool // plot ascii chart
+	TlsCert         string
+	TlsKey          string
+	Tls             bool   // enable TLS, in mode optional unless TlsMode is set
+	TlsMode         string // required, optional or off (set from Tls by default)
+	TlsCA           string // PEM CA bundle for verifying server certificate (implies TlsVerify unless TlsNoVerify)
+	TlsServerName   string // server name for SNI and certificate verification (default is host)
+	TlsVerify       bool   // client verifies server certificate, system roots unless TlsCA
+	TlsNoVerify     bool   // client does not verify server certificate even with TlsCA
+	TlsClientCA     string // PEM CA bundle: server requires client certificates signed by it
+	TlsClientCert   string // client certificate presented to server
+	TlsClientKey    string // client certificate key
+	TlsSelfSigned   bool   // server generates in-memory self-signed certificate
+	TlsPin          string // client requires server certificate with this SHA-256 fingerprint
+	TlsMinVersion   string // minimum TLS version: 1.0, 1.1, 1.2 or 1.3
+	TlsMaxVersion   string // maximum TLS version
+	TlsCiphers      string // comma-separated TLS 1.0-1.2 cipher suites, in preference order
+	TlsALPN         string // comma-separated ALPN protocols offered or accepted
+	TlsResume       bool   // client resumes TLS sessions with tickets
+	Handshakes      bool   // client measures TLS handshake rate on short connections instead of throughput
+	Cps             bool   // client measures TCP connection rate on short connections instead of throughput
+	CpsPayload      int    // bytes echoed by server on every Cps connection (0 means connect and close)
+	LocalAddr       string
+	ConnectRate     float64    // client opens at most this many connections per second (0 means unlimited)
+	DialConcurrency int        // client connection attempts in progress at once (0 means 1)
+	DialTimeout     string     // client connect timeout, including TLS handshake (empty means 10s, 0 means none)
+	DialRetries     int        // client retries failed connection attempts
+	SendFile        string     // client sends file instead of random data ("-" means stdin)
+	RecvFile        string     // server writes received data to file
+	Observers       []Observer // receive live stats in addition to built-in log and exporters
+	Metrics         string     // HTTP address for Prometheus /metrics (empty means disabled)
+	Tui             bool       // client shows live dashboard instead of log lines
+	Web             string     // HTTP address for web UI with live graphs
+	Api             string     // HTTP address for REST API starting client tests
+	Monitor         string     // client repeats test on this interval (empty means disabled)
+	MonitorHistory  int        // results kept per host in monitor mode
+	MonitorFile     string     // monitor appends results as JSON lines to this file
+	MonitorCount    int        // monitor stops after this many rounds (0 means until interrupted)
+	Agent           bool       // server accepts control requests to run tests
+	AgentSecret     string     // shared secret authenticating control requests
+	ControlAgent    string     // client asks this agent to run test towards Hosts
+	Mesh            bool       // client coordinates tests between all pairs of agents in Hosts
+	MeshParallel    bool       // run mesh tests at once instead of in turn
+	MeshReport      string     // mesh writes results matrix as JSON to this file
+
+	metrics *serverMetrics // set by Server.Start when Metrics is enabled
+	web     *webObserver   // set by Server.Start or Client.Run when Web is enabled
+	tui     *tuiObserver   // set by Client.Run when Tui is enabled
+
+	tlsRoots       *x509.CertPool   // loaded from TlsCA by Setup
+	tlsClientRoots *x509.CertPool   // loaded from TlsClientCA by Setup
+	tlsClientCert  *tls.Certificate // loaded from TlsClientCert and TlsClientKey by Setup
+	tlsPin         []byte           // parsed from TlsPin by Setup
+	tlsServerCert  *tls.Certificate // generated by Server.Start when TlsSelfSigned
+
+	// parsed from TlsMinVersion, TlsMaxVersion, TlsCiphers and TlsALPN by Setup
+	tlsMinVersion   uint16
+	tlsMaxVersion   uint16
+	tlsCipherSuites []uint16
+	tlsALPN         []string
+
+	tlsSessions tls.ClientSessionCache // created by Setup when TlsResume
+
+	dialTimeout time.Duration // parsed from DialTimeout by Setup
 }
 
 type Options struct {
 	ReportInterval time.Duration
 	TotalDuration  time.Duration
+	Omit           time.Duration // discard initial period from averages
 	ReadSize       int
 	WriteSize      int
 	PassiveServer  bool              // suppress server send
 	MaxSpeed       float64           // mbps
 	Table          map[string]string // send optional information client->server
+	Verify         bool              // check payload integrity
+	VerifySeed     int64             // seed for deterministic payload
+	MaxBytes       int64             // stop after transferring bytes (0 means unlimited)
+	BlockCount     int64             // stop after writing blocks (0 means unlimited)
+	Control        bool              // connection carries control request instead of test
+	Short          bool              // connection closes right after ack, measuring establishment only
+	Echo           int               // short connection: server echoes this many bytes after ack
+}
+
+// limit returns the number of bytes after which every stream stops.
+// Zero means unlimited.
+func (opt Options) limit() int64 {
+	limit := opt.MaxBytes
+	if opt.BlockCount > 0 {
+		blocks := opt.BlockCount * int64(opt.WriteSize)
+		if limit == 0 || blocks < limit {
+			limit = blocks
+		}
+	}
+	return limit
+}
+
+// Setup validates configuration and parses its string fields into Opt.
+// Empty string fields keep values already present in Opt.
+func (app *Config) Setup() error {
+	if errChart := BadExportFilename("-chart", app.Chart); errChart != nil {
+		return errChart
+	}
+
+	if errExport := BadExportFilename("-export", app.Export); errExport != nil {
+		return errExport
+	}
+
+	if errCsv := BadExportFilename("-csv", app.Csv); errCsv != nil {
+		return errCsv
+	}
+
+	if errRecv := BadRecvFilename("-recvFile", app.RecvFile); errRecv != nil {
+		return errRecv
+	}
+
+	if app.SendFile != "" {
+		if app.Opt.Verify {
+			return fmt.Errorf("-sendFile and -verify are mutually exclusive")
+		}
+		if app.SendFile == "-" && (app.Connections != 1 || len(app.Hosts) > 1) {
+			return fmt.Errorf("-sendFile - (stdin) requires single host and single connection")
+		}
+		app.Opt.PassiveServer = true
+	}
+
+	if app.ReportInterval != "" {
+		app.ReportInterval = DefaultTimeUnit(app.ReportInterval)
+		var errInterval error
+		app.Opt.ReportInterval, errInterval = time.ParseDuration(app.ReportInterval)
+		if errInterval != nil {
+			return fmt.Errorf("bad reportInterval: %q: %v", app.ReportInterval, errInterval)
+		}
+	}
+
+	if app.TotalDuration == "" && app.Opt.TotalDuration == 0 {
+		// size-limited TCP test runs to completion
+		if app.Udp || (app.Bytes == "" && app.Opt.limit() == 0) {
+			app.TotalDuration = defaultTotalDuration
+		}
+	}
+
+	if app.TotalDuration != "" {
+		app.TotalDuration = DefaultTimeUnit(app.TotalDuration)
+		var errDuration error
+		app.Opt.TotalDuration, errDuration = time.ParseDuration(app.TotalDuration)
+		if errDuration != nil {
+			return fmt.Errorf("bad totalDuration: %q: %v", app.TotalDuration, errDuration)
+		}
+	}
+
+	if app.Omit != "" {
+		app.Omit = DefaultTimeUnit(app.Omit)
+		var errOmit error
+		app.Opt.Omit, errOmit = time.ParseDuration(app.Omit)
+		if errOmit != nil {
+			return fmt.Errorf("bad omit: %q: %v", app.Omit, errOmit)
+		}
+	}
+
+	if app.Opt.TotalDuration > 0 && app.Opt.Omit >= app.Opt.TotalDuration {
+		return fmt.Errorf("omit %v must be shorter than totalDuration %v", app.Opt.Omit, app.Opt.TotalDuration)
+	}
+
+	if app.Bytes != "" {
+		var errBytes error
+		app.Opt.MaxBytes, errBytes = ParseBytes(app.Bytes)
+		if errBytes != nil {
+			return fmt.Errorf("bad bytes: %q: %v", app.Bytes, errBytes)
+		}
+	}
+
+	if app.DialTimeout == "" {
+		app.DialTimeout = defaultDialTimeout
+	}
+	app.DialTimeout = DefaultTimeUnit(app.DialTimeout)
+	var errTimeout error
+	app.dialTimeout, errTimeout = time.ParseDuration(app.DialTimeout)
+	if errTimeout != nil {
+		return fmt.Errorf("bad dialTimeout: %q: %v", app.DialTimeout, errTimeout)
+	}
+	if app.dialTimeout < 0 {
+		return fmt.Errorf("bad dialTimeout: %q: negative", app.DialTimeout)
+	}
+	if app.DialRetries < 0 {
+		return fmt.Errorf("bad dialRetries: %d", app.DialRetries)
+	}
+
+	if app.Connections < 1 {
+		return fmt.Errorf("bad connections: %d", app.Connections)
+	}
+	if app.Opt.ReadSize < 1 {
+		return fmt.Errorf("bad readSize: %d", app.Opt.ReadSize)
+	}
+	if app.Opt.WriteSize < 1 {
+		return fmt.Errorf("bad writeSize: %d", app.Opt.WriteSize)
+	}
+	if app.Opt.ReportInterval <= 0 {
+		return fmt.Errorf("bad reportInterval: %v", app.Opt.ReportInterval)
+	}
+
+	switch app.TlsMode {
+	case "":
+		app.TlsMode = TLSOff
+		if app.Tls {
+			app.TlsMode = TLSOptional
+		}
+	case TLSRequired, TLSOptional, TLSOff:
+	default:
+		return fmt.Errorf("bad tls mode: %q: expecting %s, %s or %s", app.TlsMode, TLSRequired, TLSOptional, TLSOff)
+	}
+	app.Tls = app.TlsMode != TLSOff
+	if app.Udp && app.TlsMode == TLSRequired {
+		return fmt.Errorf("UDP does not support TLS mode %s", TLSRequired)
+	}
+
+	if app.TlsCA != "" && app.tlsRoots == nil {
+		roots, errCA := loadCertPool(app.TlsCA)
+		if errCA != nil {
+			return fmt.Errorf("bad ca: %v", errCA)
+		}
+		app.tlsRoots = roots
+	}
+	if app.TlsCA != "" && !app.TlsNoVerify {
+		app.TlsVerify = true // CA is given in order to verify
+	}
+	if app.TlsVerify && app.TlsNoVerify {
+		return fmt.Errorf("tlsVerify conflicts with tlsNoVerify")
+	}
+
+	if app.TlsClientCA != "" && app.tlsClientRoots == nil {
+		roots, errCA := loadCertPool(app.TlsClientCA)
+		if errCA != nil {
+			return fmt.Errorf("bad clientCA: %v", errCA)
+		}
+		app.tlsClientRoots = roots
+	}
+
+	if (app.TlsClientCert == "") != (app.TlsClientKey == "") {
+		return fmt.Errorf("client certificate requires both clientCert and clientKey")
+	}
+	if app.TlsClientCert != "" && app.tlsClientCert == nil {
+		cert, errCert := tls.LoadX509KeyPair(app.TlsClientCert, app.TlsClientKey)
+		if errCert != nil {
+			return fmt.Errorf("bad client certificate: %v", errCert)
+		}
+		app.tlsClientCert = &cert
+	}
+
+	if app.TlsPin != "" {
+		pin, errPin := parseFingerprint(app.TlsPin)
+		if errPin != nil {
+			return fmt.Errorf("bad tlsPin: %q: %v", app.TlsPin, errPin)
+		}
+		app.tlsPin = pin
+	}
+
+	var errVersion error
+	if app.tlsMinVersion, errVersion = parseTLSVersion(app.TlsMinVersion); errVersion != nil {
+		return fmt.Errorf("bad tlsMinVersion: %q: %v", app.TlsMinVersion, errVersion)
+	}
+	if app.tlsMaxVersion, errVersion = parseTLSVersion(app.TlsMaxVersion); errVersion != nil {
+		return fmt.Errorf("bad tlsMaxVersion: %q: %v", app.TlsMaxVersion, errVersion)
+	}
+	if app.tlsMinVersion != 0 && app.tlsMaxVersion != 0 && app.tlsMinVersion > app.tlsMaxVersion {
+		return fmt.Errorf("tlsMinVersion %s above tlsMaxVersion %s", app.TlsMinVersion, app.TlsMaxVersion)
+	}
+	var errCiphers error
+	if app.tlsCipherSuites, errCiphers = parseCipherSuites(app.TlsCiphers); errCiphers != nil {
+		return fmt.Errorf("bad tlsCiphers: %v", errCiphers)
+	}
+	app.tlsALPN = nil
+	if app.TlsALPN != "" {
+		app.tlsALPN = strings.Split(app.TlsALPN, ",")
+	}
+
+	if app.ConnectRate < 0 {
+		return fmt.Errorf("bad connectRate: %v", app.ConnectRate)
+	}
+	if app.DialConcurrency < 0 {
+		return fmt.Errorf("bad dialConcurrency: %d", app.DialConcurrency)
+	}
+	if app.DialConcurrency == 0 {
+		app.DialConcurrency = 1
+	}
+
+	if app.Handshakes && app.Cps {
+		return fmt.Errorf("handshakes and cps modes are mutually exclusive")
+	}
+	if app.CpsPayload < 0 {
+		return fmt.Errorf("bad cpsPayload: %d", app.CpsPayload)
+	}
+
+	if app.TlsResume && app.tlsSessions == nil {
+		app.tlsSessions = tls.NewLRUClientSessionCache(0)
+	}
+
+	if app.Agent && app.AgentSecret == "" {
+		return fmt.Errorf("agent mode requires agent secret")
+	}
+
+	if app.Api != "" && app.AgentSecret == "" {
+		return fmt.Errorf("REST API requires agent secret as bearer token")
+	}
+
+	if len(app.Listeners) == 0 {
+		app.Listeners = []string{app.DefaultPort}
+	}
+
+	return nil
 }
 
 func (h *HostList) String() string {
@@ -74,3 +381,48 @@ func DefaultTimeUnit(s string) string {
 	}
 	return s
 }
+
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"T", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseBytes parses size strings like "500000", "10GB" or "64KiB".
+// Empty string means zero, otherwise size must be at least one byte.
+func ParseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	factor := int64(1)
+	num := s
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			factor = u.factor
+			num = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("parseBytes: bad size: %q", s)
+	}
+	size := n * float64(factor)
+	if size < 1 || size >= math.MaxInt64 {
+		return 0, fmt.Errorf("parseBytes: size out of range: %q", s)
+	}
+	return int64(size), nil
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBytes(t *testing.T) {
+	expectParseBytes(t, "", 0)
+	expectParseBytes(t, "500000", 500000)
+	expectParseBytes(t, "10GB", 10000000000)
+	expectParseBytes(t, "10G", 10000000000)
+	expectParseBytes(t, "1.5MB", 1500000)
+	expectParseBytes(t, "64KiB", 65536)
+	expectParseBytes(t, "100B", 100)
+
+	for _, bad := range []string{"x", "GB", "-1", "10XB", "0", "0B", "0.5", "inf", "-Inf", "NaN", "1e30", "9.3EB", "10000000T"} {
+		if _, err := ParseBytes(bad); err == nil {
+			t.Errorf("ParseBytes: %q: expected error", bad)
+		}
+	}
+}
+
+func expectParseBytes(t *testing.T, s string, wanted int64) {
+	result, err := ParseBytes(s)
+	if err != nil {
+		t.Errorf("expectParseBytes: %q: %v", s, err)
+		return
+	}
+	if result != wanted {
+		t.Errorf("expectParseBytes: %q result=%d wanted=%d", s, result, wanted)
+	}
+}
+
+func TestOptionsLimit(t *testing.T) {
+	opt := Options{WriteSize: 1000}
+	if limit := opt.limit(); limit != 0 {
+		t.Errorf("unlimited: limit=%d", limit)
+	}
+	opt.BlockCount = 5
+	if limit := opt.limit(); limit != 5000 {
+		t.Errorf("blockCount: limit=%d wanted=5000", limit)
+	}
+	opt.MaxBytes = 3500
+	if limit := opt.limit(); limit != 3500 {
+		t.Errorf("bytes+blockCount: limit=%d wanted=3500", limit)
+	}
+}
+
+func TestSetupOmit(t *testing.T) {
+	for _, c := range []struct {
+		omit     string
+		duration string
+		wantErr  bool
+	}{
+		{"1s", "10s", false},
+		{"10s", "10s", true},
+		{"20s", "10s", true},
+		{"20s", "0", false}, // size-limited test
+	} {
+		app := Config{Connections: 1, ReportInterval: "1s", Omit: c.omit, TotalDuration: c.duration,
+			Opt: Options{ReadSize: 1, WriteSize: 1}}
+		if err := app.Setup(); (err != nil) != c.wantErr {
+			t.Errorf("omit=%s totalDuration=%s: err=%v wantErr=%v", c.omit, c.duration, err, c.wantErr)
+		}
+	}
+}
+
+func TestSetupTotalDuration(t *testing.T) {
+	for _, c := range []struct {
+		duration string
+		bytes    string
+		udp      bool
+		wanted   time.Duration
+	}{
+		{"", "", false, 10 * time.Second},
+		{"", "1MB", false, 0}, // size-limited test runs to completion
+		{"", "1MB", true, 10 * time.Second},
+		{"0", "", false, 0},
+		{"3s", "", false, 3 * time.Second},
+	} {
+		app := Config{Connections: 1, ReportInterval: "1s", TotalDuration: c.duration, Bytes: c.bytes, Udp: c.udp,
+			Opt: Options{ReadSize: 1, WriteSize: 1}}
+		if err := app.Setup(); err != nil {
+			t.Errorf("totalDuration=%q bytes=%q: %v", c.duration, c.bytes, err)
+			continue
+		}
+		if app.Opt.TotalDuration != c.wanted {
+			t.Errorf("totalDuration=%q bytes=%q udp=%v: duration=%v wanted=%v", c.duration, c.bytes, c.udp, app.Opt.TotalDuration, c.wanted)
+		}
+	}
+}
+
+func TestSetupDialTimeout(t *testing.T) {
+	for _, c := range []struct {
+		timeout string
+		wanted  time.Duration
+		wantErr bool
+	}{
+		{"", 10 * time.Second, false},
+		{"0", 0, false}, // wait forever
+		{"2", 2 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"-1s", 0, true},
+		{"x", 0, true},
+	} {
+		app := Config{Connections: 1, ReportInterval: "1s", DialTimeout: c.timeout,
+			Opt: Options{ReadSize: 1, WriteSize: 1}}
+		err := app.Setup()
+		if (err != nil) != c.wantErr {
+			t.Errorf("dialTimeout=%q: err=%v wantErr=%v", c.timeout, err, c.wantErr)
+			continue
+		}
+		if err == nil && app.dialTimeout != c.wanted {
+			t.Errorf("dialTimeout=%q: timeout=%v wanted=%v", c.timeout, app.dialTimeout, c.wanted)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Control protocol for TCP/TLS, run by a controller against an agent
+// (a server started with Config.Agent):
+//
+// 1. controller sends Options with Control set, server replies ack
+// carrying a random nonce
+// 2. controller sends ControlRequest authenticated by Config.AgentSecret
+// 3. agent runs client test towards ControlRequest.Host
+// 4. agent sends ControlResponse and closes
+
+// controlNonce is the ack table key for the control authentication nonce.
+const controlNonce = "nonce"
+
+// ControlRequest tells an agent which test to run.
+type ControlRequest struct {
+	Host          string // target host[:port] as reachable from agent
+	Connections   int
+	Udp           bool
+	Tls           bool
+	TlsMode       string
+	TlsMinVersion string
+	TlsMaxVersion string
+	TlsCiphers    string
+	TlsALPN       string
+	TlsResume     bool
+	PassiveClient bool
+	Opt           Options
+	Auth          string // hex HMAC-SHA256 of nonce and request keyed by shared secret
+}
+
+// ControlResponse carries agent test results back to the controller.
+type ControlResponse struct {
+	Reading     Aggregate
+	Writing     Aggregate
+	Connections int // connections attempted
+	Failed      int // connections failed
+	Error       string
+}
+
+func newControlRequest(app *Config, target string) ControlRequest {
+	return ControlRequest{
+		Host:          target,
+		Connections:   app.Connections,
+		Udp:           app.Udp,
+		Tls:           app.Tls,
+		TlsMode:       app.TlsMode,
+		TlsMinVersion: app.TlsMinVersion,
+		TlsMaxVersion: app.TlsMaxVersion,
+		TlsCiphers:    app.TlsCiphers,
+		TlsALPN:       app.TlsALPN,
+		TlsResume:     app.TlsResume,
+		PassiveClient: app.PassiveClient,
+		Opt:           app.Opt,
+	}
+}
+
+// config builds agent client configuration from request.
+func (req ControlRequest) config(agent *Config) *Config {
+	return &Config{
+		Hosts:         HostList{req.Host},
+		DefaultPort:   agent.DefaultPort,
+		Connections:   req.Connections,
+		Udp:           req.Udp,
+		Tls:           req.Tls,
+		TlsMode:       req.TlsMode,
+		TlsMinVersion: req.TlsMinVersion,
+		TlsMaxVersion: req.TlsMaxVersion,
+		TlsCiphers:    req.TlsCiphers,
+		TlsALPN:       req.TlsALPN,
+		TlsResume:     req.TlsResume,
+		PassiveClient: req.PassiveClient,
+		Opt:           req.Opt,
+		LocalAddr:     agent.LocalAddr,
+		TlsCA:         agent.TlsCA, // agent verifies targets with its own trust settings
+		TlsVerify:     agent.TlsVerify,
+		TlsNoVerify:   agent.TlsNoVerify,
+		TlsPin:        agent.TlsPin,
+		TlsServerName: agent.TlsServerName,
+		TlsClientCert: agent.TlsClientCert,
+		TlsClientKey:  agent.TlsClientKey,
+	}
+}
+
+func newNonce() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// controlAuth computes authenticator of request req for nonce. It covers
+// every request field but Auth, encoded as JSON since map keys are
+// sorted, unlike gob.
+func controlAuth(secret, nonce string, req ControlRequest) (string, error) {
+	req.Auth = ""
+	buf, errMarshal := json.Marshal(req)
+	if errMarshal != nil {
+		return "", errMarshal
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{0})
+	mac.Write(buf)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// authenticate checks request was issued by a controller knowing secret.
+func (req ControlRequest) authenticate(secret, nonce string) bool {
+	if secret == "" || nonce == "" || req.Auth == "" {
+		return false
+	}
+	auth, errAuth := controlAuth(secret, nonce, req)
+	if errAuth != nil {
+		return false
+	}
+	return hmac.Equal([]byte(req.Auth), []byte(auth))
+}
+
+// dialControl connects to agent, trying TLS first when enabled.
+func dialControl(ctx context.Context, app *Config, agent string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: app.dialTimeout}
+	hh := appendPortIfMissing(agent, app.DefaultPort)
+	if app.Tls {
+		conn, _, errTLS := tlsDial(ctx, app, dialer, "tcp", hh)
+		if errTLS == nil {
+			return conn, nil
+		}
+		if app.tlsStrict() {
+			return nil, errTLS
+		}
+		slog.Warn("dialControl: trying TLS: failure", "agent", hh, "err", errTLS)
+	}
+	return dialer.DialContext(ctx, "tcp", hh)
+}
+
+// Control asks agent to run a client test towards target, returning
+// its results. The agent must share Config.AgentSecret with app.
+// Test options are taken from app.
+func Control(ctx context.Context, app *Config, agent, target string) (*ControlResponse, error) {
+	if errSetup := app.Setup(); errSetup != nil {
+		return nil, errSetup
+	}
+	if app.AgentSecret == "" {
+		return nil, errors.New("control: missing agent secret")
+	}
+
+	resp, err := control(ctx, app, agent, target)
+	if err != nil {
+		return nil, err
+	}
+
+	logResult("control", "agent", agent, "target", target, "writeMbps", resp.Writing.Mbps, "readMbps", resp.Reading.Mbps,
+		"connections", resp.Connections, "failed", resp.Failed, "error", resp.Error)
+
+	if resp.Error != "" {
+		return resp, fmt.Errorf("control: %s: agent: %s", agent, resp.Error)
+	}
+
+	return resp, nil
+}
+
+// control asks agent to run test towards target, returning its results.
+func control(ctx context.Context, app *Config, agent, target string) (*ControlResponse, error) {
+	conn, errDial := dialControl(ctx, app, agent)
+	if errDial != nil {
+		return nil, fmt.Errorf("control: dial %s: %v", agent, errDial)
+	}
+	defer conn.Close()
+
+	if app.Opt.TotalDuration > 0 {
+		conn.SetDeadline(time.Now().Add(app.Opt.TotalDuration + 2*stopGrace))
+	}
+
+	// unblock pending read on cancellation
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-finished:
+		}
+	}()
+
+	opt := Options{Control: true}
+	if errOpt := gob.NewEncoder(conn).Encode(&opt); errOpt != nil {
+		return nil, fmt.Errorf("control: %s: sending options: %v", agent, errOpt)
+	}
+
+	var a ack
+	if errAck := ackRecv(false, conn, &a); errAck != nil {
+		return nil, fmt.Errorf("control: %s: receiving ack: %v", agent, errAck)
+	}
+
+	nonce := a.Table[controlNonce]
+	if nonce == "" {
+		return nil, fmt.Errorf("control: %s: missing nonce, not an agent?", agent)
+	}
+
+	req := newControlRequest(app, target)
+	auth, errAuth := controlAuth(app.AgentSecret, nonce, req)
+	if errAuth != nil {
+		return nil, fmt.Errorf("control: %s: authenticating request: %v", agent, errAuth)
+	}
+	req.Auth = auth
+	if errReq := gob.NewEncoder(conn).Encode(&req); errReq != nil {
+		return nil, fmt.Errorf("control: %s: sending request: %v", agent, errReq)
+	}
+
+	var resp ControlResponse
+	if errResp := gob.NewDecoder(conn).Decode(&resp); errResp != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("control: %s: receiving response: %v", agent, errResp)
+	}
+
+	return &resp, nil
+}
+
+// handleControl serves control request on agent.
+func handleControl(ctx context.Context, app *Config, conn net.Conn, nonce string) {
+	var req ControlRequest
+	if errReq := gob.NewDecoder(exactReader{conn}).Decode(&req); errReq != nil {
+		slog.Error("handleControl: receiving request", "remote", conn.RemoteAddr(), "err", errReq)
+		return
+	}
+
+	// controller going away cancels test
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		var b [1]byte
+		conn.Read(b[:])
+		cancel()
+	}()
+
+	var resp ControlResponse
+
+	switch {
+	case !app.Agent:
+		slog.Warn("handleControl: agent mode disabled", "remote", conn.RemoteAddr())
+		resp.Error = "agent mode disabled"
+	case !req.authenticate(app.AgentSecret, nonce):
+		slog.Warn("handleControl: authentication failed", "remote", conn.RemoteAddr())
+		app.metrics.handshakeFailure(connProto(conn))
+		resp.Error = "authentication failed"
+	default:
+		resp = runControl(ctx, app, conn, req)
+	}
+
+	if errResp := gob.NewEncoder(conn).Encode(&resp); errResp != nil {
+		slog.Error("handleControl: sending response", "remote", conn.RemoteAddr(), "err", errResp)
+	}
+}
+
+// runControl runs requested client test on agent.
+func runControl(ctx context.Context, app *Config, conn net.Conn, req ControlRequest) ControlResponse {
+	slog.Info("handleControl: running test", "controller", conn.RemoteAddr(), "target", req.Host)
+
+	var resp ControlResponse
+
+	result, errRun := NewClient(req.config(app)).Run(ctx)
+	if result != nil {
+		resp.Reading = result.Reading
+		resp.Writing = result.Writing
+		resp.Connections = len(result.Connections)
+		resp.Failed = result.Failed()
+	}
+	if errRun != nil {
+		resp.Error = errRun.Error()
+	}
+
+	return resp
+}
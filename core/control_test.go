@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	agent, agentHost := startAgent(t, true)
+	defer agent.Shutdown()
+	target, targetHost := startAgent(t, false)
+	defer target.Shutdown()
+
+	app := &Config{
+		Connections:    2,
+		ReportInterval: "1s",
+		Bytes:          "100KB",
+		AgentSecret:    testSecret,
+		Opt:            testOptions,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := Control(ctx, app, agentHost, targetHost)
+	if err != nil {
+		t.Fatalf("control: %v", err)
+	}
+	if resp.Connections != 2 || resp.Failed != 0 {
+		t.Errorf("connections=%d failed=%d wanted=2 0", resp.Connections, resp.Failed)
+	}
+	if resp.Reading.Mbps <= 0 || resp.Writing.Mbps <= 0 {
+		t.Errorf("reading=%d writing=%d", resp.Reading.Mbps, resp.Writing.Mbps)
+	}
+}
+
+func TestControlBadSecret(t *testing.T) {
+	agent, agentHost := startAgent(t, true)
+	defer agent.Shutdown()
+
+	app := &Config{
+		Connections:    1,
+		ReportInterval: "1s",
+		Bytes:          "100KB",
+		AgentSecret:    "wrong",
+		Opt:            testOptions,
+	}
+
+	_, err := Control(context.Background(), app, agentHost, agentHost)
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("expected authentication failure: %v", err)
+	}
+}
+
+func TestControlRequestTLS(t *testing.T) {
+	app := &Config{Tls: true, TlsMode: TLSRequired, TlsMinVersion: "1.2", TlsMaxVersion: "1.2",
+		TlsCiphers: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", TlsALPN: "goben", TlsResume: true}
+	cfg := newControlRequest(app, "target:8080").config(&Config{})
+	if cfg.TlsMinVersion != app.TlsMinVersion || cfg.TlsMaxVersion != app.TlsMaxVersion ||
+		cfg.TlsCiphers != app.TlsCiphers || cfg.TlsALPN != app.TlsALPN || !cfg.TlsResume {
+		t.Errorf("TLS settings not forwarded: %+v", cfg)
+	}
+}
+
+func TestControlAuth(t *testing.T) {
+	req := ControlRequest{Host: "target:8080", Connections: 1, TlsMode: TLSOptional,
+		Opt: Options{ReadSize: 1000, WriteSize: 1000, TotalDuration: time.Second, Table: map[string]string{"a": "1", "b": "2"}}}
+	auth, errAuth := controlAuth(testSecret, "nonce1", req)
+	if errAuth != nil {
+		t.Fatalf("auth: %v", errAuth)
+	}
+	req.Auth = auth
+
+	if !req.authenticate(testSecret, "nonce1") {
+		t.Errorf("valid request rejected")
+	}
+	if req.authenticate(testSecret, "nonce2") {
+		t.Errorf("replayed request accepted")
+	}
+	if req.authenticate("", "") {
+		t.Errorf("request accepted without secret")
+	}
+
+	for name, tamper := range map[string]func(r *ControlRequest){
+		"host":        func(r *ControlRequest) { r.Host = "other:8080" },
+		"connections": func(r *ControlRequest) { r.Connections = 1000 },
+		"tlsMode":     func(r *ControlRequest) { r.TlsMode = TLSOff },
+		"tlsCiphers":  func(r *ControlRequest) { r.TlsCiphers = "TLS_RSA_WITH_AES_128_CBC_SHA" },
+		"duration":    func(r *ControlRequest) { r.Opt.TotalDuration = time.Hour },
+		"table":       func(r *ControlRequest) { r.Opt.Table = map[string]string{"a": "1"} },
+	} {
+		tampered := req
+		tampered.Opt.Table = map[string]string{"a": "1", "b": "2"}
+		tamper(&tampered)
+		if tampered.authenticate(testSecret, "nonce1") {
+			t.Errorf("tampered request accepted: %s", name)
+		}
+	}
+}
@@ -8,9 +8,12 @@ import (
 
 // CSV fields
 const (
-	Dir  = 0 // Direction
-	Time = 1 // Timestamp
-	Rate = 2 // Rate
+	Dir        = 0 // Direction
+	Time       = 1 // Timestamp
+	Rate       = 2 // Rate
+	Proto      = 3 // TCP, TLS or UDP
+	TlsVersion = 4 // TLS version, empty without TLS
+	TlsCipher  = 5 // TLS cipher suite, empty without TLS
 )
 
 func ExportCsv(filename string, info *ExportInfo) error {
@@ -22,12 +25,20 @@ func ExportCsv(filename string, info *ExportInfo) error {
 
 	w := csv.NewWriter(out)
 
-	entry := []string{"DIRECTION", "TIME", "RATE"}
+	entry := []string{"DIRECTION", "TIME", "RATE", "PROTO", "TLS_VERSION", "TLS_CIPHER"}
 
 	if errHeader := w.Write(entry); errHeader != nil {
 		return errHeader
 	}
 
+	entry[Proto] = info.Proto
+	entry[TlsVersion] = ""
+	entry[TlsCipher] = ""
+	if info.TLS != nil {
+		entry[TlsVersion] = info.TLS.Version
+		entry[TlsCipher] = info.TLS.CipherSuite
+	}
+
 	entry[Dir] = "input"
 	for i, x := range info.Input.XValues {
 		entry[Time] = x.String()
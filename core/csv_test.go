@@ -0,0 +1,35 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportCsv(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.csv")
+	info := &ExportInfo{
+		Input:  ChartData{XValues: []time.Time{time.Unix(0, 0).UTC()}, YValues: []float64{10}},
+		Output: ChartData{XValues: []time.Time{time.Unix(1, 0).UTC()}, YValues: []float64{20}},
+		Proto:  "TLS",
+		TLS:    &TLSInfo{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256"},
+	}
+	if err := ExportCsv(filename, info); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	data, errRead := os.ReadFile(filename)
+	if errRead != nil {
+		t.Fatalf("read: %v", errRead)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"DIRECTION,TIME,RATE,PROTO,TLS_VERSION,TLS_CIPHER",
+		"input,1970-01-01 00:00:00 +0000 UTC,10,TLS,TLS 1.3,TLS_AES_128_GCM_SHA256",
+		"output,1970-01-01 00:00:01 +0000 UTC,20,TLS,TLS 1.3,TLS_AES_128_GCM_SHA256",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("csv:\n%s\nwanted:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
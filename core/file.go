@@ -0,0 +1,106 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileStats separates time spent on disk from time spent on network,
+// in order to tell which side limited the transfer.
+type fileStats struct {
+	size     int64
+	diskTime time.Duration
+	netTime  time.Duration
+}
+
+func mbpsFor(size int64, elap time.Duration) int64 {
+	if elap <= 0 {
+		return 0
+	}
+	return int64(float64(8*size) / (1000000 * elap.Seconds()))
+}
+
+func (s *fileStats) report(conn, label, filename string) {
+	logResult("file", "conn", conn, "stream", label, "file", filename, "bytes", s.size,
+		"networkMbps", mbpsFor(s.size, s.netTime), "networkTime", s.netTime,
+		"diskMbps", mbpsFor(s.size, s.diskTime), "diskTime", s.diskTime)
+}
+
+// fileSender reads payload from file and writes it to conn.
+// It returns io.EOF when the file is exhausted.
+func fileSender(file io.Reader, conn io.Writer, s *fileStats) call {
+	return func(p []byte) (int, error) {
+		diskStart := time.Now()
+		n, errRead := file.Read(p)
+		s.diskTime += time.Since(diskStart)
+		if n < 1 {
+			if errRead == nil {
+				return 0, nil
+			}
+			return 0, errRead
+		}
+
+		netStart := time.Now()
+		written, errWrite := conn.Write(p[:n])
+		s.netTime += time.Since(netStart)
+		s.size += int64(written)
+
+		return written, errWrite
+	}
+}
+
+// fileReceiver reads payload from conn and writes it to file.
+func fileReceiver(conn io.Reader, file io.Writer, s *fileStats) call {
+	return func(p []byte) (int, error) {
+		netStart := time.Now()
+		n, errRead := conn.Read(p)
+		s.netTime += time.Since(netStart)
+		if n < 1 {
+			return 0, errRead
+		}
+
+		diskStart := time.Now()
+		_, errWrite := file.Write(p[:n])
+		s.diskTime += time.Since(diskStart)
+		s.size += int64(n)
+		if errWrite != nil {
+			return n, errWrite
+		}
+
+		return n, errRead
+	}
+}
+
+// openSendFile opens file for sending; "-" means stdin.
+func openSendFile(filename string) (io.ReadCloser, error) {
+	if filename == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(filename)
+}
+
+// recvFilename expands optional '%d' and '%s' in receive filename.
+func recvFilename(pattern string, c int, remote fmt.Stringer) string {
+	if strings.Contains(pattern, "%d") && strings.Contains(pattern, "%s") {
+		return fmt.Sprintf(pattern, c, remote)
+	}
+	return pattern
+}
+
+// BadRecvFilename requires '%d' and '%s' unless data is discarded.
+func BadRecvFilename(parameter, filename string) error {
+	if filename == os.DevNull {
+		return nil
+	}
+	return BadExportFilename(parameter, filename)
+}
+
+// closeWrite half-closes conn so the peer reader sees EOF.
+func closeWrite(conn interface{}) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
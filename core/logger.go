@@ -0,0 +1,36 @@
+package core
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// LevelResult is the level of test results, which are shown even in
+// quiet mode. It sits between Info (progress) and Warn.
+const LevelResult = slog.LevelInfo + 2
+
+// NewLogger creates a logger writing records from level up to w,
+// formatted as JSON or as key=value text.
+func NewLogger(w io.Writer, level slog.Level, json bool) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if l, ok := a.Value.Any().(slog.Level); ok && l == LevelResult {
+					a.Value = slog.StringValue("RESULT")
+				}
+			}
+			return a
+		},
+	}
+	if json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// logResult logs test results through the default logger.
+func logResult(msg string, args ...any) {
+	slog.Log(context.Background(), LevelResult, msg, args...)
+}
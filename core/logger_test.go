@@ -0,0 +1,36 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelResult, false)
+
+	logger.Info("progress")
+	logger.Log(context.Background(), LevelResult, "average", "mbps", 100)
+
+	out := buf.String()
+	if strings.Contains(out, "progress") {
+		t.Errorf("quiet logger printed info: %q", out)
+	}
+	if !strings.Contains(out, "level=RESULT msg=average mbps=100") {
+		t.Errorf("missing result: %q", out)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, slog.LevelDebug, true)
+
+	logger.Log(context.Background(), LevelResult, "average", "mbps", 100)
+
+	if out := buf.String(); !strings.Contains(out, `"level":"RESULT","msg":"average","mbps":100`) {
+		t.Errorf("unexpected JSON: %q", out)
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// MeshCell records test run by agent From towards agent To.
+type MeshCell struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	WriteMbps int64  `json:"writeMbps"` // From -> To
+	ReadMbps  int64  `json:"readMbps"`  // To -> From
+	Failed    int    `json:"failedConnections"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MeshResult records tests between all ordered pairs of agents.
+type MeshResult struct {
+	Hosts []string   `json:"hosts"`
+	Cells []MeshCell `json:"cells"`
+}
+
+// Mesh coordinates tests between every pair of agents in Config.Hosts.
+// Each agent is a server started with Config.Agent.
+type Mesh struct {
+	app *Config
+}
+
+// NewMesh creates mesh coordinator for configuration app.
+func NewMesh(app *Config) *Mesh {
+	return &Mesh{app: app}
+}
+
+// Run tests every agent towards every other agent, either in turn or
+// in parallel, and gathers the results matrix.
+func (m *Mesh) Run(ctx context.Context) (*MeshResult, error) {
+	app := m.app
+
+	if errSetup := app.Setup(); errSetup != nil {
+		return nil, errSetup
+	}
+	if len(app.Hosts) < 2 {
+		return nil, errors.New("mesh: at least two hosts required")
+	}
+	if app.Udp {
+		return nil, errors.New("mesh: UDP is not supported")
+	}
+	if app.AgentSecret == "" {
+		return nil, errors.New("mesh: missing agent secret")
+	}
+
+	result := &MeshResult{}
+	for _, h := range app.Hosts {
+		result.Hosts = append(result.Hosts, appendPortIfMissing(h, app.DefaultPort))
+	}
+	for _, from := range result.Hosts {
+		for _, to := range result.Hosts {
+			if from != to {
+				result.Cells = append(result.Cells, MeshCell{From: from, To: to})
+			}
+		}
+	}
+
+	slog.Info("mesh: starting", "agents", len(result.Hosts), "tests", len(result.Cells), "parallel", app.MeshParallel)
+
+	var wg sync.WaitGroup
+	for i := range result.Cells {
+		if ctx.Err() != nil {
+			break
+		}
+		cell := &result.Cells[i]
+		if app.MeshParallel {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.test(ctx, cell)
+			}()
+			continue
+		}
+		m.test(ctx, cell)
+	}
+	wg.Wait()
+
+	if app.MeshReport != "" {
+		if errReport := result.export(app.MeshReport); errReport != nil {
+			slog.Error("mesh: report", "file", app.MeshReport, "err", errReport)
+		}
+	}
+
+	if errCtx := ctx.Err(); errCtx != nil {
+		return result, errCtx
+	}
+
+	if failed := result.failed(); failed == len(result.Cells) {
+		return result, fmt.Errorf("mesh: all %d tests failed", failed)
+	}
+
+	return result, nil
+}
+
+// test asks agent cell.From to run test towards cell.To.
+func (m *Mesh) test(ctx context.Context, cell *MeshCell) {
+	slog.Info("mesh: test", "from", cell.From, "to", cell.To)
+
+	resp, errControl := control(ctx, m.app, cell.From, cell.To)
+	if errControl != nil {
+		cell.Error = errControl.Error()
+	} else {
+		cell.WriteMbps = resp.Writing.Mbps
+		cell.ReadMbps = resp.Reading.Mbps
+		cell.Failed = resp.Failed
+		cell.Error = resp.Error
+	}
+
+	logResult("mesh", "from", cell.From, "to", cell.To, "writeMbps", cell.WriteMbps, "readMbps", cell.ReadMbps, "failed", cell.Failed, "error", cell.Error)
+}
+
+func (r *MeshResult) failed() int {
+	var count int
+	for _, c := range r.Cells {
+		if c.Error != "" {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *MeshResult) cell(from, to string) *MeshCell {
+	for i := range r.Cells {
+		if r.Cells[i].From == from && r.Cells[i].To == to {
+			return &r.Cells[i]
+		}
+	}
+	return nil
+}
+
+// Print writes results matrix: row agent sends to column agent, in Mbps.
+func (r *MeshResult) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "from \\ to (Mbps)\t%s\t\n", strings.Join(r.Hosts, "\t"))
+	for _, from := range r.Hosts {
+		fmt.Fprintf(tw, "%s\t", from)
+		for _, to := range r.Hosts {
+			c := r.cell(from, to)
+			switch {
+			case c == nil:
+				fmt.Fprint(tw, "-\t")
+			case c.Error != "":
+				fmt.Fprint(tw, "error\t")
+			default:
+				fmt.Fprintf(tw, "%d\t", c.WriteMbps)
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+func (r *MeshResult) export(filename string) error {
+	buf, errMarshal := json.MarshalIndent(r, "", "  ")
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return os.WriteFile(filename, append(buf, '\n'), 0640)
+}
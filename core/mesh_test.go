@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+func startAgent(t *testing.T, agent bool) (*Server, string) {
+	var secret string
+	if agent {
+		secret = testSecret
+	}
+	return startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Agent:          agent,
+		AgentSecret:    secret,
+		Opt:            testOptions,
+	})
+}
+
+func TestMesh(t *testing.T) {
+	server1, host1 := startAgent(t, true)
+	defer server1.Shutdown()
+	server2, host2 := startAgent(t, true)
+	defer server2.Shutdown()
+
+	for _, parallel := range []bool{false, true} {
+		mesh := NewMesh(&Config{
+			Hosts:          HostList{host1, host2},
+			Connections:    1,
+			ReportInterval: "1s",
+			Bytes:          "100KB",
+			MeshParallel:   parallel,
+			AgentSecret:    testSecret,
+			Opt:            testOptions,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result, err := mesh.Run(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("parallel=%v: mesh run: %v", parallel, err)
+		}
+
+		if len(result.Cells) != 2 {
+			t.Fatalf("parallel=%v: cells=%d wanted=2", parallel, len(result.Cells))
+		}
+		for _, c := range result.Cells {
+			if c.Error != "" || c.Failed != 0 {
+				t.Errorf("parallel=%v: %s->%s: failed=%d error=%s", parallel, c.From, c.To, c.Failed, c.Error)
+			}
+			if c.WriteMbps <= 0 || c.ReadMbps <= 0 {
+				t.Errorf("parallel=%v: %s->%s: write=%d read=%d", parallel, c.From, c.To, c.WriteMbps, c.ReadMbps)
+			}
+		}
+
+		var buf bytes.Buffer
+		result.Print(&buf)
+		if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+			t.Errorf("parallel=%v: matrix lines=%d wanted=3:\n%s", parallel, lines, buf.String())
+		}
+	}
+}
+
+func TestMeshAgentDisabled(t *testing.T) {
+	server1, host1 := startAgent(t, false)
+	defer server1.Shutdown()
+	server2, host2 := startAgent(t, false)
+	defer server2.Shutdown()
+
+	mesh := NewMesh(&Config{
+		Hosts:          HostList{host1, host2},
+		Connections:    1,
+		ReportInterval: "1s",
+		Bytes:          "100KB",
+		AgentSecret:    testSecret,
+		Opt:            testOptions,
+	})
+
+	result, err := mesh.Run(context.Background())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, c := range result.Cells {
+		if !strings.Contains(c.Error, "agent mode disabled") {
+			t.Errorf("%s->%s: error=%q", c.From, c.To, c.Error)
+		}
+	}
+}
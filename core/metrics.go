@@ -0,0 +1,186 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metricsProtos lists protocols always exported, even with zero counts.
+var metricsProtos = []string{"TCP", "TLS", "UDP"}
+
+// serverMetrics collects server counters exported in Prometheus text format.
+// Per-session throughput is fed by the Observer interface.
+type serverMetrics struct {
+	NopObserver
+	mutex             sync.Mutex
+	sessions          map[string]int64 // proto
+	active            map[string]int64 // proto
+	handshakeFailures map[string]int64 // proto
+	bytesReceived     map[string]int64 // proto
+	bytesSent         map[string]int64 // proto
+	streams           map[Stream]*streamGauge
+}
+
+// streamGauge tracks one live session stream.
+type streamGauge struct {
+	mbps    float64
+	counted int64 // bytes already added to totals
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		sessions:          map[string]int64{},
+		active:            map[string]int64{},
+		handshakeFailures: map[string]int64{},
+		bytesReceived:     map[string]int64{},
+		bytesSent:         map[string]int64{},
+		streams:           map[Stream]*streamGauge{},
+	}
+}
+
+// sessionStart records a new session. Nil metrics are ignored.
+func (m *serverMetrics) sessionStart(proto string) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.sessions[proto]++
+	m.active[proto]++
+	m.mutex.Unlock()
+}
+
+// sessionEnd records session termination. Nil metrics are ignored.
+func (m *serverMetrics) sessionEnd(proto string) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.active[proto]--
+	m.mutex.Unlock()
+}
+
+// handshakeFailure records failed TLS handshake or options/ack exchange.
+// Nil metrics are ignored.
+func (m *serverMetrics) handshakeFailure(proto string) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.handshakeFailures[proto]++
+	m.mutex.Unlock()
+}
+
+// addBytes updates byte totals with stream progress. Caller holds mutex.
+func (m *serverMetrics) addBytes(s Stream, g *streamGauge, total int64) {
+	delta := total - g.counted
+	g.counted = total
+	if s.Input {
+		m.bytesReceived[s.Proto] += delta
+	} else {
+		m.bytesSent[s.Proto] += delta
+	}
+}
+
+func (m *serverMetrics) Sample(s Stream, sample Sample) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g, found := m.streams[s]
+	if !found {
+		g = &streamGauge{}
+		m.streams[s] = g
+	}
+	g.mbps = sample.Mbps
+	m.addBytes(s, g, g.counted+sample.Bytes)
+}
+
+func (m *serverMetrics) StreamDone(s Stream, result StreamResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g, found := m.streams[s]
+	if !found {
+		g = &streamGauge{}
+	}
+	m.addBytes(s, g, result.Bytes)
+	delete(m.streams, s)
+}
+
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// write renders metrics in Prometheus text exposition format.
+func (m *serverMetrics) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	perProto := func(name, kind, help string, values map[string]int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, proto := range metricsProtos {
+			fmt.Fprintf(w, "%s{proto=%q} %d\n", name, proto, values[proto])
+		}
+	}
+
+	perProto("goben_sessions_total", "counter", "Test sessions accepted.", m.sessions)
+	perProto("goben_active_connections", "gauge", "Test sessions in progress.", m.active)
+	perProto("goben_handshake_failures_total", "counter", "Failed TLS handshakes or options exchanges.", m.handshakeFailures)
+
+	fmt.Fprintf(w, "# HELP goben_bytes_total Payload bytes transferred.\n# TYPE goben_bytes_total counter\n")
+	for _, proto := range metricsProtos {
+		fmt.Fprintf(w, "goben_bytes_total{proto=%q,direction=\"received\"} %d\n", proto, m.bytesReceived[proto])
+		fmt.Fprintf(w, "goben_bytes_total{proto=%q,direction=\"sent\"} %d\n", proto, m.bytesSent[proto])
+	}
+
+	fmt.Fprintf(w, "# HELP goben_session_mbps Per-session throughput over last report interval.\n# TYPE goben_session_mbps gauge\n")
+	var lines []string
+	for s, g := range m.streams {
+		direction := "sent"
+		if s.Input {
+			direction = "received"
+		}
+		lines = append(lines, fmt.Sprintf("goben_session_mbps{proto=%q,remote=%q,conn=%q,direction=%q} %g\n",
+			s.Proto, s.Remote, s.Conn, direction, g.mbps))
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		io.WriteString(w, l)
+	}
+}
+
+// serveHandler spawns HTTP server for handler at pattern on addr until ctx is done.
+func serveHandler(ctx context.Context, wg *sync.WaitGroup, addr, pattern string, handler http.Handler) (net.Addr, error) {
+	listener, errListen := net.Listen("tcp", addr)
+	if errListen != nil {
+		return nil, fmt.Errorf("serveHandler: %s: %v", addr, errListen)
+	}
+
+	slog.Info("serveHandler: serving", "addr", listener.Addr(), "path", pattern)
+
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: stopGrace}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			slog.Error("serveHandler", "addr", listener.Addr(), "err", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	return listener.Addr(), nil
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestServerMetrics(t *testing.T) {
+	m := newServerMetrics()
+
+	m.sessionStart("TLS")
+	m.sessionStart("TLS")
+	m.sessionEnd("TLS")
+	m.handshakeFailure("TCP")
+
+	s := Stream{Conn: "0/0", Remote: "10.0.0.1:5000", Proto: "TLS", Label: "serverReader", Input: true}
+	m.Sample(s, Sample{Bytes: 1000, Mbps: 8})
+	m.Sample(s, Sample{Bytes: 500, Mbps: 4})
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		`goben_sessions_total{proto="TLS"} 2`,
+		`goben_active_connections{proto="TLS"} 1`,
+		`goben_handshake_failures_total{proto="TCP"} 1`,
+		`goben_bytes_total{proto="TLS",direction="received"} 1500`,
+		`goben_session_mbps{proto="TLS",remote="10.0.0.1:5000",conn="0/0",direction="received"} 4`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing line: %s", line)
+		}
+	}
+
+	m.StreamDone(s, StreamResult{Bytes: 1800})
+
+	buf.Reset()
+	m.write(&buf)
+	out = buf.String()
+
+	if !strings.Contains(out, `goben_bytes_total{proto="TLS",direction="received"} 1800`+"\n") {
+		t.Errorf("final bytes not accounted: %s", out)
+	}
+	if strings.Contains(out, "goben_session_mbps{") {
+		t.Errorf("finished session still reported: %s", out)
+	}
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProbeResult records one scheduled test to a host.
+type ProbeResult struct {
+	Host      string    `json:"host"`
+	Time      time.Time `json:"time"`
+	ReadMbps  int64     `json:"readMbps"`
+	WriteMbps int64     `json:"writeMbps"`
+	ReadCps   int64     `json:"readCps"`
+	WriteCps  int64     `json:"writeCps"`
+	Failed    int       `json:"failedConnections"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Monitor runs a test to every host in Config.Hosts on a schedule,
+// keeping a rolling history of results per host.
+type Monitor struct {
+	app     *Config
+	mutex   sync.Mutex
+	history map[string][]ProbeResult
+	probes  map[string]int64 // total probes per host
+	failed  map[string]int64 // failed probes per host
+	addr    net.Addr
+}
+
+// NewMonitor creates monitor for configuration app.
+func NewMonitor(app *Config) *Monitor {
+	return &Monitor{
+		app:     app,
+		history: map[string][]ProbeResult{},
+		probes:  map[string]int64{},
+		failed:  map[string]int64{},
+	}
+}
+
+// Run probes hosts every Config.Monitor interval until ctx is cancelled,
+// or Config.MonitorCount rounds are done. Hosts are probed in turn, so
+// that tests do not disturb each other.
+func (m *Monitor) Run(ctx context.Context) error {
+	app := m.app
+
+	if errSetup := app.Setup(); errSetup != nil {
+		return errSetup
+	}
+	if len(app.Hosts) == 0 {
+		return errors.New("monitor: no hosts")
+	}
+
+	interval, errInterval := time.ParseDuration(DefaultTimeUnit(app.Monitor))
+	if errInterval != nil || interval <= 0 {
+		return fmt.Errorf("monitor: bad interval: %q", app.Monitor)
+	}
+	if app.MonitorCount < 0 {
+		return fmt.Errorf("monitor: bad count: %d", app.MonitorCount)
+	}
+	if app.Opt.TotalDuration >= interval || (app.Opt.TotalDuration <= 0 && app.Opt.limit() == 0) {
+		return fmt.Errorf("monitor: totalDuration=%v must be shorter than interval=%v", app.Opt.TotalDuration, interval)
+	}
+
+	var results io.Writer
+	if app.MonitorFile != "" {
+		file, errOpen := os.OpenFile(app.MonitorFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if errOpen != nil {
+			return fmt.Errorf("monitor: %v", errOpen)
+		}
+		defer file.Close()
+		results = file
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	if app.Metrics != "" {
+		ctxMetrics, cancel := context.WithCancel(ctx)
+		defer cancel() // stop metrics server before waiting for it
+		addr, errMetrics := serveHandler(ctxMetrics, &wg, app.Metrics, "/metrics", m)
+		if errMetrics != nil {
+			return errMetrics
+		}
+		m.addr = addr
+	}
+
+	slog.Info("monitor: starting", "interval", interval, "totalDuration", app.Opt.TotalDuration, "hosts", app.Hosts)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for round := 1; ; round++ {
+		for _, h := range app.Hosts {
+			if ctx.Err() != nil {
+				return nil
+			}
+			p := m.probe(ctx, h)
+			if ctx.Err() != nil {
+				return nil // interrupted probe is not recorded
+			}
+			m.record(p)
+			if results != nil {
+				if errWrite := writeProbe(results, p); errWrite != nil {
+					slog.Error("monitor: results file", "file", app.MonitorFile, "err", errWrite)
+				}
+			}
+		}
+
+		if round == app.MonitorCount {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe runs a single test to host h.
+func (m *Monitor) probe(ctx context.Context, h string) ProbeResult {
+	app := *m.app // copy
+	app.Hosts = HostList{h}
+	app.Web = "" // web UI and dashboard are for single tests only
+	app.Tui = false
+
+	p := ProbeResult{Host: appendPortIfMissing(h, app.DefaultPort), Time: time.Now()}
+
+	result, err := NewClient(&app).Run(ctx)
+	if result != nil {
+		p.ReadMbps = result.Reading.Mbps
+		p.WriteMbps = result.Writing.Mbps
+		p.ReadCps = result.Reading.Cps
+		p.WriteCps = result.Writing.Cps
+		p.Failed = result.Failed()
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	logResult("monitor: probe", "host", p.Host, "readMbps", p.ReadMbps, "writeMbps", p.WriteMbps, "failed", p.Failed, "error", p.Error)
+
+	return p
+}
+
+func (m *Monitor) record(p ProbeResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.probes[p.Host]++
+	if p.Error != "" {
+		m.failed[p.Host]++
+	}
+
+	hist := append(m.history[p.Host], p)
+	if size := m.app.MonitorHistory; size > 0 && len(hist) > size {
+		hist = hist[len(hist)-size:]
+	}
+	m.history[p.Host] = hist
+}
+
+// writeProbe appends probe result as one JSON line.
+func writeProbe(w io.Writer, p ProbeResult) error {
+	buf, errMarshal := json.Marshal(p)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	_, errWrite := w.Write(append(buf, '\n'))
+	return errWrite
+}
+
+// History returns copy of rolling history per host, oldest first.
+func (m *Monitor) History() map[string][]ProbeResult {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	hist := map[string][]ProbeResult{}
+	for h, list := range m.history {
+		hist[h] = append([]ProbeResult{}, list...)
+	}
+	return hist
+}
+
+// MetricsAddr returns bound metrics address, nil if disabled.
+func (m *Monitor) MetricsAddr() net.Addr {
+	return m.addr
+}
+
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// write renders probe metrics in Prometheus text exposition format.
+func (m *Monitor) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var hosts []string
+	for h := range m.history {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	metric := func(name, kind, help string, value func(h string, hist []ProbeResult) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, h := range hosts {
+			io.WriteString(w, value(h, m.history[h]))
+		}
+	}
+
+	metric("goben_probes_total", "counter", "Scheduled tests run.", func(h string, hist []ProbeResult) string {
+		return fmt.Sprintf("goben_probes_total{host=%q} %d\n", h, m.probes[h])
+	})
+	metric("goben_probe_failures_total", "counter", "Scheduled tests failed.", func(h string, hist []ProbeResult) string {
+		return fmt.Sprintf("goben_probe_failures_total{host=%q} %d\n", h, m.failed[h])
+	})
+	metric("goben_probe_success", "gauge", "Whether last test succeeded.", func(h string, hist []ProbeResult) string {
+		var success int
+		if hist[len(hist)-1].Error == "" {
+			success = 1
+		}
+		return fmt.Sprintf("goben_probe_success{host=%q} %d\n", h, success)
+	})
+	metric("goben_probe_timestamp_seconds", "gauge", "Start time of last test.", func(h string, hist []ProbeResult) string {
+		return fmt.Sprintf("goben_probe_timestamp_seconds{host=%q} %d\n", h, hist[len(hist)-1].Time.Unix())
+	})
+	metric("goben_probe_mbps", "gauge", "Throughput of last test.", func(h string, hist []ProbeResult) string {
+		last := hist[len(hist)-1]
+		return fmt.Sprintf("goben_probe_mbps{host=%q,direction=\"read\"} %d\ngoben_probe_mbps{host=%q,direction=\"write\"} %d\n",
+			h, last.ReadMbps, h, last.WriteMbps)
+	})
+	metric("goben_probe_mbps_avg", "gauge", "Average throughput of successful tests in rolling history.", func(h string, hist []ProbeResult) string {
+		var read, write, count int64
+		for _, p := range hist {
+			if p.Error == "" {
+				read += p.ReadMbps
+				write += p.WriteMbps
+				count++
+			}
+		}
+		if count > 0 {
+			read /= count
+			write /= count
+		}
+		return fmt.Sprintf("goben_probe_mbps_avg{host=%q,direction=\"read\"} %d\ngoben_probe_mbps_avg{host=%q,direction=\"write\"} %d\n",
+			h, read, h, write)
+	})
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	dir := t.TempDir()
+	resultsFile := filepath.Join(dir, "results.jsonl")
+
+	m := NewMonitor(&Config{
+		Hosts:          HostList{host},
+		Connections:    1,
+		ReportInterval: "1s",
+		Bytes:          "100KB",
+		Monitor:        "200ms",
+		MonitorHistory: 2,
+		MonitorFile:    resultsFile,
+		MonitorCount:   3,
+		Opt:            testOptions,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("monitor run: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("monitor did not stop after count: %v", ctx.Err())
+	}
+	if probes := m.probes[host]; probes != 3 {
+		t.Errorf("probes=%d wanted=3", probes)
+	}
+
+	hist := m.History()[host]
+	if len(hist) != 2 {
+		t.Errorf("history=%d wanted=2 (rolling)", len(hist))
+	}
+	for _, p := range hist {
+		if p.Error != "" {
+			t.Errorf("probe error: %s", p.Error)
+		}
+	}
+
+	data, errRead := os.ReadFile(resultsFile)
+	if errRead != nil {
+		t.Fatalf("results file: %v", errRead)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("results file lines=%d wanted=3", lines)
+	}
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	if !strings.Contains(buf.String(), "goben_probe_success{host=\""+host+"\"} 1\n") {
+		t.Errorf("missing probe success metric: %s", buf.String())
+	}
+}
@@ -2,13 +2,24 @@ package core
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 )
 
+// exactReader prevents gob from wrapping the connection in a bufio.Reader,
+// which would consume payload bytes following the message.
+type exactReader struct {
+	io.Reader
+}
 
+func (r exactReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
 
 type ack struct {
 	Magic string
@@ -26,21 +37,19 @@ func ackSend(udp bool, conn io.Writer, a ack) error {
 
 	// prevent sending wrong magic
 	if a.Magic != ackMagic {
-		m := fmt.Sprintf("ackSend: bad magic: expected=[%s] got=[%s]", ackMagic, a.Magic)
-		log.Print(m)
-		return fmt.Errorf(m)
+		return fmt.Errorf("ackSend: bad magic: expected=[%s] got=[%s]", ackMagic, a.Magic)
 	}
 
 	if udp {
 		var buf bytes.Buffer
 		enc := gob.NewEncoder(&buf)
 		if errEnc := enc.Encode(&a); errEnc != nil {
-			log.Printf("ackSend: UDP encoding: %v", errEnc)
+			slog.Error("ackSend: UDP encoding", "err", errEnc)
 			return errEnc
 		}
 		_, errWrite := conn.Write(buf.Bytes())
 		if errWrite != nil {
-			log.Printf("ackSend: UDP write: %v", errWrite)
+			slog.Error("ackSend: UDP write", "err", errWrite)
 			return errWrite
 		}
 		return nil
@@ -48,7 +57,7 @@ func ackSend(udp bool, conn io.Writer, a ack) error {
 
 	enc := gob.NewEncoder(conn)
 	if errEnc := enc.Encode(&a); errEnc != nil {
-		log.Printf("ackSend: TCP failure: %v", errEnc)
+		slog.Error("ackSend: TCP failure", "err", errEnc)
 		return errEnc
 	}
 
@@ -59,23 +68,54 @@ func ackSend(udp bool, conn io.Writer, a ack) error {
 func ackRecv(udp bool, conn io.Reader, a *ack) error {
 
 	if udp {
-		m := fmt.Sprintf("ackRecv: UDP FIXME WRITEME")
-		log.Print(m)
-		return fmt.Errorf(m)
+		return fmt.Errorf("ackRecv: UDP FIXME WRITEME")
 	}
 
-	dec := gob.NewDecoder(conn)
+	dec := gob.NewDecoder(exactReader{conn})
 	if errDec := dec.Decode(a); errDec != nil {
-		log.Printf("ackRecv: TCP failure: %v", errDec)
+		slog.Error("ackRecv: TCP failure", "err", errDec)
 		return errDec
 	}
 
 	// prevent receiving wrong magic
 	if a.Magic != ackMagic {
-		m := fmt.Sprintf("ackRecv: bad magic: expected=[%s] got=[%s]", ackMagic, a.Magic)
-		log.Print(m)
-		return fmt.Errorf(m)
+		return fmt.Errorf("ackRecv: bad magic: expected=[%s] got=[%s]", ackMagic, a.Magic)
 	}
 
 	return nil
 }
+
+// FinalCounters carries server final counters after its payload.
+type FinalCounters struct {
+	ReadBytes           int64
+	WriteBytes          int64
+	ReadCorrupted       int64 // received bytes failing verification
+	ReadCorruptedOffset int64 // offset of first corrupted byte received
+}
+
+const finalMagic = "goben-fn"
+
+// finalSize is the fixed length of the encoded final counters trailer.
+const finalSize = len(finalMagic) + 32
+
+func (f FinalCounters) encode() []byte {
+	buf := make([]byte, finalSize)
+	copy(buf, finalMagic)
+	binary.BigEndian.PutUint64(buf[len(finalMagic):], uint64(f.ReadBytes))
+	binary.BigEndian.PutUint64(buf[len(finalMagic)+8:], uint64(f.WriteBytes))
+	binary.BigEndian.PutUint64(buf[len(finalMagic)+16:], uint64(f.ReadCorrupted))
+	binary.BigEndian.PutUint64(buf[len(finalMagic)+24:], uint64(f.ReadCorruptedOffset))
+	return buf
+}
+
+func decodeFinal(buf []byte) (FinalCounters, error) {
+	var f FinalCounters
+	if len(buf) != finalSize || string(buf[:len(finalMagic)]) != finalMagic {
+		return f, fmt.Errorf("decodeFinal: missing final counters trailer")
+	}
+	f.ReadBytes = int64(binary.BigEndian.Uint64(buf[len(finalMagic):]))
+	f.WriteBytes = int64(binary.BigEndian.Uint64(buf[len(finalMagic)+8:]))
+	f.ReadCorrupted = int64(binary.BigEndian.Uint64(buf[len(finalMagic)+16:]))
+	f.ReadCorruptedOffset = int64(binary.BigEndian.Uint64(buf[len(finalMagic)+24:]))
+	return f, nil
+}
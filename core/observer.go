@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Observer receives live test statistics.
+// Methods are called concurrently from connection goroutines.
+// Servers report only Sample and StreamDone.
+type Observer interface {
+	Sample(s Stream, sample Sample)           // periodic report
+	StreamDone(s Stream, result StreamResult) // stream average
+	ConnectionDone(c *ConnectionResult)       // client connection finished
+	TestDone(r *Result)                       // client test finished
+}
+
+// NopObserver ignores all events. Embed it to implement only some methods.
+type NopObserver struct{}
+
+// Sample implements Observer.
+func (NopObserver) Sample(s Stream, sample Sample) {}
+
+// StreamDone implements Observer.
+func (NopObserver) StreamDone(s Stream, result StreamResult) {}
+
+// ConnectionDone implements Observer.
+func (NopObserver) ConnectionDone(c *ConnectionResult) {}
+
+// TestDone implements Observer.
+func (NopObserver) TestDone(r *Result) {}
+
+// Stream identifies one direction of a connection.
+type Stream struct {
+	Conn   string // parallel connection index "c/connections"
+	Remote string // peer address
+	Proto  string // TCP, TLS or UDP
+	Label  string // reporting function, e.g. clientReader
+	Input  bool   // true for receiving, false for sending
+}
+
+// Sample records one report interval.
+type Sample struct {
+	Time    time.Time
+	Bytes   int64   // bytes transferred during interval
+	Mbps    float64 // Megabit/s during interval
+	Cps     int64   // Call/s during interval
+	Omitted bool    // sample falls within omitted initial period
+
+	// TCP segments retransmitted on connection so far, reported on
+	// writer streams where the platform supports it
+	Retransmits int64
+}
+
+// multiObserver dispatches events to every observer in turn.
+type multiObserver []Observer
+
+func (m multiObserver) Sample(s Stream, sample Sample) {
+	for _, o := range m {
+		o.Sample(s, sample)
+	}
+}
+
+func (m multiObserver) StreamDone(s Stream, result StreamResult) {
+	for _, o := range m {
+		o.StreamDone(s, result)
+	}
+}
+
+func (m multiObserver) ConnectionDone(c *ConnectionResult) {
+	for _, o := range m {
+		o.ConnectionDone(c)
+	}
+}
+
+func (m multiObserver) TestDone(r *Result) {
+	for _, o := range m {
+		o.TestDone(r)
+	}
+}
+
+// observer builds built-in observers selected by app followed by app.Observers.
+func (app *Config) observer() Observer {
+	m := multiObserver{logObserver{}}
+	if app.Csv != "" || app.Export != "" || app.Chart != "" {
+		m = append(m, exportObserver{csv: app.Csv, yaml: app.Export, chart: app.Chart})
+	}
+	if app.Ascii {
+		m = append(m, asciiObserver{})
+	}
+	if app.metrics != nil {
+		m = append(m, app.metrics)
+	}
+	if app.web != nil {
+		m = append(m, app.web)
+	}
+	if app.tui != nil {
+		m = append(m, app.tui)
+	}
+	return append(m, app.Observers...)
+}
+
+// logObserver writes live stats to the log.
+type logObserver struct {
+	NopObserver
+}
+
+func (logObserver) Sample(s Stream, sample Sample) {
+	kind := "report"
+	if sample.Omitted {
+		kind = "omitted"
+	}
+	logResult(kind, "conn", s.Conn, "stream", s.Label, "input", s.Input, "mbps", int64(sample.Mbps), "cps", sample.Cps)
+}
+
+func (logObserver) StreamDone(s Stream, result StreamResult) {
+	logResult("average", "conn", s.Conn, "stream", s.Label, "proto", s.Proto, "input", s.Input, "bytes", result.Bytes, "mbps", result.Mbps, "cps", result.Cps)
+}
+
+func (logObserver) TestDone(r *Result) {
+	logResult("aggregate reading", "mbps", r.Reading.Mbps, "cps", r.Reading.Cps)
+	logResult("aggregate writing", "mbps", r.Writing.Mbps, "cps", r.Writing.Cps)
+}
+
+// exportObserver saves connection results to CSV, YAML and PNG files.
+type exportObserver struct {
+	NopObserver
+	csv   string
+	yaml  string
+	chart string
+}
+
+func (e exportObserver) ConnectionDone(c *ConnectionResult) {
+	info := c.exportInfo()
+
+	if e.csv != "" {
+		filename := fmt.Sprintf(e.csv, c.Index, c.Remote)
+		slog.Info("exporting CSV test results", "file", filename)
+		errExport := ExportCsv(filename, &info)
+		if errExport != nil {
+			slog.Error("export CSV", "file", filename, "err", errExport)
+		}
+	}
+
+	if e.yaml != "" {
+		filename := fmt.Sprintf(e.yaml, c.Index, c.Remote)
+		slog.Info("exporting YAML test results", "file", filename)
+		errExport := export(filename, &info)
+		if errExport != nil {
+			slog.Error("export YAML", "file", filename, "err", errExport)
+		}
+	}
+
+	if e.chart != "" {
+		filename := fmt.Sprintf(e.chart, c.Index, c.Remote)
+		slog.Info("rendering chart", "file", filename)
+		errRender := chartRender(filename, &info.Input, &info.Output)
+		if errRender != nil {
+			slog.Error("render PNG", "file", filename, "err", errRender)
+		}
+	}
+}
+
+// asciiObserver plots connection results as ascii chart.
+type asciiObserver struct {
+	NopObserver
+}
+
+func (asciiObserver) ConnectionDone(c *ConnectionResult) {
+	info := c.exportInfo()
+	plotascii(&info, c.Remote, c.Index)
+}
@@ -2,7 +2,7 @@ package core
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/guptarohit/asciigraph"
 )
@@ -12,16 +12,17 @@ func plotascii(info *ExportInfo, remote string, index int) {
 	height := 10
 	width := 70
 
-	if len(info.Input.YValues) > 0 {
+	// asciigraph cannot interpolate a single point
+	if len(info.Input.YValues) > 1 {
 		caption := fmt.Sprintf("Input Mbps: %s Connection %d", remote, index)
-		log.Printf("%s input:", remote)
+		slog.Info("plot input", "remote", remote)
 		input := asciigraph.Plot(info.Input.YValues, asciigraph.Caption(caption), asciigraph.Height(height), asciigraph.Width(width))
 		fmt.Println(input)
 	}
 
-	if len(info.Output.YValues) > 0 {
+	if len(info.Output.YValues) > 1 {
 		caption := fmt.Sprintf("Output Mbps: %s Connection %d", remote, index)
-		log.Printf("%s output:", remote)
+		slog.Info("plot output", "remote", remote)
 		output := asciigraph.Plot(info.Output.YValues, asciigraph.Caption(caption), asciigraph.Height(height), asciigraph.Width(width))
 		fmt.Println(output)
 	}
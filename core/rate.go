@@ -0,0 +1,308 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Latency summarizes latency distribution.
+type Latency struct {
+	Min time.Duration `json:"min" yaml:"min"`
+	P50 time.Duration `json:"p50" yaml:"p50"`
+	P90 time.Duration `json:"p90" yaml:"p90"`
+	P99 time.Duration `json:"p99" yaml:"p99"`
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+// newLatency computes percentiles of samples, sorting them in place.
+func newLatency(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+	return Latency{
+		Min: samples[0],
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// RateResult records short connections opened to one host.
+type RateResult struct {
+	Host      string
+	Succeeded int64
+	Resumed   int64 // TLS sessions resumed
+	Failed    int64
+	Elapsed   time.Duration
+	Rate      float64 // successful connections per second
+	Latency   Latency // TLS handshake or TCP connect latency
+	Err       error   // last failure, nil if none
+}
+
+// RateTest measures how fast short connections are established,
+// either TLS handshakes (Config.Handshakes) or TCP connections
+// (Config.Cps), opening them to every host in Config.Hosts for
+// Config.TotalDuration. Each host gets Config.Connections concurrent
+// workers.
+type RateTest struct {
+	app *Config
+}
+
+// NewRateTest creates connection rate test for configuration app.
+func NewRateTest(app *Config) *RateTest {
+	return &RateTest{app: app}
+}
+
+// rateCounter accumulates one host results while workers run.
+type rateCounter struct {
+	succeeded atomic.Int64
+	resumed   atomic.Int64
+	failed    atomic.Int64
+	mutex     sync.Mutex
+	latencies []time.Duration
+	err       error
+}
+
+// Run opens short connections until Config.TotalDuration elapses or
+// ctx is done, returning results per host.
+func (r *RateTest) Run(ctx context.Context) ([]*RateResult, error) {
+	app := r.app
+
+	if errSetup := app.Setup(); errSetup != nil {
+		return nil, errSetup
+	}
+	if app.Udp {
+		return nil, errors.New("rate: UDP is not supported")
+	}
+	if app.Handshakes && !app.Tls {
+		return nil, errors.New("rate: handshake mode requires TLS")
+	}
+
+	// server closes connection right after ack, or echoed payload
+	cfg := *app
+	cfg.Opt.Short = true
+	cfg.Opt.Echo = app.CpsPayload
+
+	connect, label := rateConnect, "connections"
+	if app.Handshakes {
+		connect, label = rateHandshake, "handshakes"
+	}
+
+	slog.Info("rate: starting", "hosts", len(app.Hosts), "workers", app.Connections, "resume", app.TlsResume, "duration", app.Opt.TotalDuration)
+
+	ctx, cancel := context.WithTimeout(ctx, app.Opt.TotalDuration)
+	defer cancel()
+
+	start := time.Now()
+	var hosts []string
+	counters := map[string]*rateCounter{}
+	var wg sync.WaitGroup
+	for _, h := range app.Hosts {
+		hh := appendPortIfMissing(h, app.DefaultPort)
+		counter := &rateCounter{}
+		hosts = append(hosts, hh)
+		counters[hh] = counter
+		for i := 0; i < app.Connections; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rateWorker(ctx, &cfg, hh, connect, counter)
+			}()
+		}
+	}
+
+	stop := rateReport(hosts, counters, app.Opt.ReportInterval)
+	wg.Wait()
+	stop()
+
+	elapsed := time.Since(start)
+
+	var results []*RateResult
+	var failed int
+	for _, hh := range hosts {
+		c := counters[hh]
+		result := &RateResult{
+			Host:      hh,
+			Succeeded: c.succeeded.Load(),
+			Resumed:   c.resumed.Load(),
+			Failed:    c.failed.Load(),
+			Elapsed:   elapsed,
+			Latency:   newLatency(c.latencies),
+			Err:       c.err,
+		}
+		result.Rate = float64(result.Succeeded) / elapsed.Seconds()
+		if result.Succeeded == 0 {
+			failed++
+		}
+		results = append(results, result)
+		logResult(label, "host", hh, "succeeded", result.Succeeded, "resumed", result.Resumed, "failed", result.Failed,
+			"rate", int64(result.Rate), "min", result.Latency.Min, "p50", result.Latency.P50, "p90", result.Latency.P90,
+			"p99", result.Latency.P99, "max", result.Latency.Max, "err", result.Err)
+	}
+
+	if failed == len(results) {
+		return results, fmt.Errorf("rate: all %d hosts failed", failed)
+	}
+
+	return results, nil
+}
+
+// rateFunc performs one short connection, returning its latency and
+// whether TLS session was resumed.
+type rateFunc func(ctx context.Context, app *Config, dialer net.Dialer, hh string) (time.Duration, bool, error)
+
+// rateWorker repeats short connections to hh until ctx is done.
+func rateWorker(ctx context.Context, app *Config, hh string, connect rateFunc, counter *rateCounter) {
+	dialer := net.Dialer{Timeout: app.dialTimeout}
+	for ctx.Err() == nil {
+		latency, resumed, err := connect(ctx, app, dialer, hh)
+		if err != nil {
+			if ctxExpired(ctx) {
+				return // interrupted connection does not count
+			}
+			slog.Debug("rate: connection", "host", hh, "err", err)
+			counter.failed.Add(1)
+			counter.mutex.Lock()
+			counter.err = err
+			counter.mutex.Unlock()
+			// back off, sparing a failing server from reconnection storm
+			select {
+			case <-time.After(dialRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		counter.succeeded.Add(1)
+		if resumed {
+			counter.resumed.Add(1)
+		}
+		counter.mutex.Lock()
+		counter.latencies = append(counter.latencies, latency)
+		counter.mutex.Unlock()
+	}
+}
+
+// ctxExpired reports whether ctx is done or its deadline has passed.
+// Dial fails on the deadline slightly before ctx timer marks it done.
+func ctxExpired(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// rateHandshake performs one short TLS connection, returning handshake
+// duration and whether the session was resumed.
+func rateHandshake(ctx context.Context, app *Config, dialer net.Dialer, hh string) (time.Duration, bool, error) {
+	conn, handshake, errDial := tlsDial(ctx, app, dialer, "tcp", hh)
+	if errDial != nil {
+		return 0, false, errDial
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(stopGrace))
+	if errOpt := sendOptions(app, conn); errOpt != nil {
+		return 0, false, errOpt
+	}
+	// reading ack also receives TLS 1.3 session tickets
+	var a ack
+	if errAck := ackRecv(false, conn, &a); errAck != nil {
+		return 0, false, errAck
+	}
+
+	return handshake, conn.ConnectionState().DidResume, nil
+}
+
+// rateConnect performs one short TCP connection, returning connect
+// duration. Unless payload is requested, it closes without sending
+// anything. Payload is written while echo is read, since neither fits
+// socket buffers when large.
+func rateConnect(ctx context.Context, app *Config, dialer net.Dialer, hh string) (time.Duration, bool, error) {
+	start := time.Now()
+	conn, errDial := dialer.DialContext(ctx, "tcp", hh)
+	if errDial != nil {
+		return 0, false, errDial
+	}
+	connect := time.Since(start)
+	defer conn.Close()
+
+	if app.CpsPayload == 0 {
+		return connect, false, nil
+	}
+
+	conn.SetDeadline(time.Now().Add(stopGrace))
+	if errOpt := sendOptions(app, conn); errOpt != nil {
+		return 0, false, errOpt
+	}
+	var a ack
+	if errAck := ackRecv(false, conn, &a); errAck != nil {
+		return 0, false, errAck
+	}
+	buf := randBuf(app.CpsPayload)
+	written := make(chan error, 1)
+	go func() {
+		_, errWrite := conn.Write(buf)
+		written <- errWrite
+	}()
+	_, errRead := io.ReadFull(conn, make([]byte, len(buf)))
+	if errRead != nil {
+		conn.Close() // unblock writer
+	}
+	if errWrite := <-written; errWrite != nil && errRead == nil {
+		return 0, false, errWrite
+	}
+	if errRead != nil {
+		return 0, false, fmt.Errorf("reading echo: %v", errRead)
+	}
+
+	return connect, false, nil
+}
+
+// rateReport logs connections per second for every host on every
+// interval, until returned stop is called.
+func rateReport(hosts []string, counters map[string]*rateCounter, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := map[string]int64{}
+		prev := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				for _, hh := range hosts {
+					c := counters[hh]
+					succeeded := c.succeeded.Load()
+					rate := float64(succeeded-last[hh]) / now.Sub(prev).Seconds()
+					last[hh] = succeeded
+					logResult("report", "host", hh, "rate", int64(rate), "succeeded", succeeded, "failed", c.failed.Load())
+				}
+				prev = now
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	l := newLatency(samples)
+	want := Latency{Min: 1 * time.Millisecond, P50: 50 * time.Millisecond, P90: 90 * time.Millisecond,
+		P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if l != want {
+		t.Errorf("latency: got %+v, want %+v", l, want)
+	}
+	if l := newLatency(nil); l != (Latency{}) {
+		t.Errorf("empty latency: %+v", l)
+	}
+}
+
+func TestRateHandshakes(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		TlsSelfSigned:  true,
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	for _, resume := range []bool{false, true} {
+		results, err := NewRateTest(&Config{
+			Hosts:          HostList{host},
+			Connections:    2,
+			ReportInterval: "1s",
+			TotalDuration:  "300ms",
+			Tls:            true,
+			TlsResume:      resume,
+			Handshakes:     true,
+			Opt:            testOptions,
+		}).Run(context.Background())
+		if err != nil {
+			t.Fatalf("resume=%v: %v", resume, err)
+		}
+		r := results[0]
+		if r.Succeeded == 0 || r.Failed != 0 || r.Rate <= 0 || r.Latency.P50 <= 0 {
+			t.Errorf("resume=%v: unexpected result: %+v", resume, *r)
+		}
+		if resume != (r.Resumed > 0) {
+			t.Errorf("resume=%v: resumed %d of %d", resume, r.Resumed, r.Succeeded)
+		}
+	}
+}
+
+func TestRateConnections(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	// large payload exceeds socket buffers while echoed back
+	for _, payload := range []int{0, 100, 32000000} {
+		results, err := NewRateTest(&Config{
+			Hosts:          HostList{host},
+			Connections:    2,
+			ReportInterval: "1s",
+			TotalDuration:  "300ms",
+			Cps:            true,
+			CpsPayload:     payload,
+			Opt:            testOptions,
+		}).Run(context.Background())
+		if err != nil {
+			t.Fatalf("payload=%d: %v", payload, err)
+		}
+		r := results[0]
+		if r.Succeeded == 0 || r.Failed != 0 || r.Rate <= 0 || r.Latency.Max <= 0 {
+			t.Errorf("payload=%d: unexpected result: %+v", payload, *r)
+		}
+	}
+
+	results, err := NewRateTest(&Config{
+		Hosts:          HostList{"127.0.0.1:1"},
+		Connections:    1,
+		ReportInterval: "1s",
+		TotalDuration:  "100ms",
+		Cps:            true,
+		Opt:            testOptions,
+	}).Run(context.Background())
+	if err == nil || results[0].Succeeded != 0 || results[0].Failed == 0 || results[0].Err == nil {
+		t.Errorf("expected failures: err=%v", err)
+	}
+	if results[0].Failed != 1 {
+		t.Errorf("failed=%d: expected worker to back off after failure", results[0].Failed)
+	}
+}
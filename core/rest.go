@@ -0,0 +1,439 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restHistory is the number of finished tests kept by the HTTP API.
+const restHistory = 100
+
+// restMaxTests is the number of tests the HTTP API runs at once.
+const restMaxTests = 4
+
+// restMaxDuration bounds tests started through the HTTP API. Size-limited
+// tests are cancelled once it expires.
+const restMaxDuration = 10 * time.Minute
+
+// Test states reported by the HTTP API.
+const (
+	TestRunning   = "running"
+	TestDone      = "done"
+	TestFailed    = "failed"
+	TestCancelled = "cancelled"
+)
+
+// TestSpec describes a client test requested through the HTTP API.
+// Empty fields take the command-line defaults, except TLS which is
+// disabled unless requested by Tls (mode optional) or TlsMode.
+type TestSpec struct {
+	Hosts          []string `json:"hosts"`
+	Udp            bool     `json:"udp,omitempty"`
+	Tls            bool     `json:"tls,omitempty"`
+	TlsMode        string   `json:"tlsMode,omitempty"` // required, optional or off
+	Connections    int      `json:"connections,omitempty"`
+	TotalDuration  string   `json:"totalDuration,omitempty"`
+	Bytes          string   `json:"bytes,omitempty"`
+	ReportInterval string   `json:"reportInterval,omitempty"`
+	MaxSpeed       float64  `json:"maxSpeed,omitempty"` // Mbps
+	ReadSize       int      `json:"readSize,omitempty"`
+	WriteSize      int      `json:"writeSize,omitempty"`
+	PassiveClient  bool     `json:"passiveClient,omitempty"`
+	PassiveServer  bool     `json:"passiveServer,omitempty"`
+}
+
+// config builds client configuration for spec.
+func (spec TestSpec) config(app *Config) (*Config, error) {
+	if len(spec.Hosts) == 0 {
+		return nil, errors.New("no hosts")
+	}
+
+	cfg := &Config{
+		Hosts:          HostList(spec.Hosts),
+		DefaultPort:    app.DefaultPort,
+		Connections:    spec.Connections,
+		ReportInterval: spec.ReportInterval,
+		TotalDuration:  spec.TotalDuration,
+		Bytes:          spec.Bytes,
+		Udp:            spec.Udp,
+		Tls:            spec.Tls,
+		TlsMode:        spec.TlsMode,
+		PassiveClient:  spec.PassiveClient,
+		LocalAddr:      app.LocalAddr,
+		TlsCA:          app.TlsCA, // server verifies targets with its own trust settings
+		TlsVerify:      app.TlsVerify,
+		TlsNoVerify:    app.TlsNoVerify,
+		TlsPin:         app.TlsPin,
+		TlsServerName:  app.TlsServerName,
+		TlsClientCert:  app.TlsClientCert,
+		TlsClientKey:   app.TlsClientKey,
+		Opt: Options{
+			ReadSize:      spec.ReadSize,
+			WriteSize:     spec.WriteSize,
+			MaxSpeed:      spec.MaxSpeed,
+			PassiveServer: spec.PassiveServer,
+		},
+	}
+
+	if cfg.Connections == 0 {
+		cfg.Connections = 1
+	}
+	if cfg.ReportInterval == "" {
+		cfg.ReportInterval = "2s"
+	}
+	if cfg.Opt.ReadSize == 0 {
+		cfg.Opt.ReadSize = 50000
+	}
+	if cfg.Opt.WriteSize == 0 {
+		cfg.Opt.WriteSize = 50000
+	}
+
+	if errSetup := cfg.Setup(); errSetup != nil {
+		return nil, errSetup
+	}
+
+	if cfg.Opt.TotalDuration > restMaxDuration || (cfg.Opt.TotalDuration <= 0 && cfg.Opt.limit() == 0) {
+		return nil, fmt.Errorf("totalDuration must be at most %v", restMaxDuration)
+	}
+
+	return cfg, nil
+}
+
+// StreamProgress is the live state of one test stream.
+type StreamProgress struct {
+	Conn   string  `json:"conn"`
+	Remote string  `json:"remote"`
+	Stream string  `json:"stream"`
+	Input  bool    `json:"input"`
+	Bytes  int64   `json:"bytes"`
+	Mbps   float64 `json:"mbps"` // last report interval, or average when done
+	Done   bool    `json:"done"`
+}
+
+// ConnectionSummary is the final result of one test connection.
+type ConnectionSummary struct {
+	Host        string   `json:"host"`
+	Remote      string   `json:"remote,omitempty"`
+	Index       int      `json:"index"`
+	Proto       string   `json:"proto,omitempty"`
+	InputBytes  int64    `json:"inputBytes"`
+	InputMbps   int64    `json:"inputMbps"`
+	OutputBytes int64    `json:"outputBytes"`
+	OutputMbps  int64    `json:"outputMbps"`
+	TLS         *TLSInfo `json:"tls,omitempty"`
+	Error       string   `json:"error,omitempty"`
+
+	InputCorrupted  int64 `json:"inputCorrupted,omitempty"`  // verification: corrupted bytes received
+	OutputCorrupted int64 `json:"outputCorrupted,omitempty"` // verification: corrupted bytes received by server
+}
+
+// TestResult is the final result of a test requested through the HTTP API.
+type TestResult struct {
+	ReadMbps    int64               `json:"readMbps"`
+	WriteMbps   int64               `json:"writeMbps"`
+	Connections []ConnectionSummary `json:"connections"`
+}
+
+func newTestResult(r *Result) *TestResult {
+	tr := &TestResult{ReadMbps: r.Reading.Mbps, WriteMbps: r.Writing.Mbps, Connections: []ConnectionSummary{}}
+	for _, c := range r.Connections {
+		cs := ConnectionSummary{
+			Host:        c.Host,
+			Remote:      c.Remote,
+			Index:       c.Index,
+			Proto:       c.Proto,
+			InputBytes:  c.Input.Bytes,
+			InputMbps:   c.Input.Mbps,
+			OutputBytes: c.Output.Bytes,
+			OutputMbps:  c.Output.Mbps,
+			TLS:         c.TLSInfo,
+
+			InputCorrupted:  c.Input.Corrupted,
+			OutputCorrupted: c.Output.Corrupted,
+		}
+		if c.Err != nil {
+			cs.Error = c.Err.Error()
+		}
+		tr.Connections = append(tr.Connections, cs)
+	}
+	return tr
+}
+
+// TestStatus reports progress and result of a test requested through the HTTP API.
+type TestStatus struct {
+	ID       string           `json:"id"`
+	State    string           `json:"state"`
+	Spec     TestSpec         `json:"spec"`
+	Started  time.Time        `json:"started"`
+	Finished *time.Time       `json:"finished,omitempty"`
+	Progress []StreamProgress `json:"progress"`
+	Result   *TestResult      `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// restTest tracks one test started through the HTTP API. It observes
+// its own client in order to report live progress.
+type restTest struct {
+	NopObserver
+	cancel context.CancelFunc
+	mutex  sync.Mutex
+	status TestStatus
+	seq    int
+	live   map[Stream]*StreamProgress
+}
+
+func (t *restTest) progress(s Stream) *StreamProgress {
+	p, found := t.live[s]
+	if !found {
+		p = &StreamProgress{Conn: s.Conn, Remote: s.Remote, Stream: s.Label, Input: s.Input}
+		t.live[s] = p
+	}
+	return p
+}
+
+func (t *restTest) Sample(s Stream, sample Sample) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	p := t.progress(s)
+	p.Bytes += sample.Bytes
+	p.Mbps = sample.Mbps
+}
+
+func (t *restTest) StreamDone(s Stream, result StreamResult) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	p := t.progress(s)
+	p.Bytes = result.Bytes
+	p.Mbps = float64(result.Mbps)
+	p.Done = true
+}
+
+// finish records test outcome.
+func (t *restTest) finish(result *Result, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.status.Finished = &now
+	if result != nil {
+		t.status.Result = newTestResult(result)
+	}
+	switch {
+	case err == context.Canceled:
+		t.status.State = TestCancelled
+	case err != nil:
+		t.status.State = TestFailed
+		t.status.Error = err.Error()
+	default:
+		t.status.State = TestDone
+	}
+}
+
+func (t *restTest) snapshot() TestStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	st := t.status
+	st.Progress = []StreamProgress{}
+	for _, p := range t.live {
+		st.Progress = append(st.Progress, *p)
+	}
+	sort.Slice(st.Progress, func(i, j int) bool {
+		pi, pj := st.Progress[i], st.Progress[j]
+		if pi.Conn != pj.Conn {
+			return pi.Conn < pj.Conn
+		}
+		return pi.Stream < pj.Stream
+	})
+	return st
+}
+
+// restAPI serves HTTP API to start client tests and fetch results:
+//
+//	POST   /tests      start test from TestSpec, returns TestStatus
+//	GET    /tests      list TestStatus of known tests
+//	GET    /tests/ID   get TestStatus with live progress and final result
+//	DELETE /tests/ID   cancel running test
+//
+// Requests must carry Config.AgentSecret as bearer token.
+type restAPI struct {
+	app   *Config
+	ctx   context.Context // parent of every test
+	wg    *sync.WaitGroup
+	mutex sync.Mutex
+	tests map[string]*restTest
+	seq   int
+}
+
+func newRestAPI(ctx context.Context, wg *sync.WaitGroup, app *Config) *restAPI {
+	return &restAPI{app: app, ctx: ctx, wg: wg, tests: map[string]*restTest{}}
+}
+
+// authorized checks request bearer token against agent secret.
+func (api *restAPI) authorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || api.app.AgentSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.app.AgentSecret)) == 1
+}
+
+func (api *restAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/tests") {
+		writeError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tests"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		api.start(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		api.list(w)
+	case id != "" && r.Method == http.MethodGet:
+		if t := api.find(id); t != nil {
+			writeJSON(w, http.StatusOK, t.snapshot())
+			return
+		}
+		writeError(w, http.StatusNotFound, "test not found: "+id)
+	case id != "" && r.Method == http.MethodDelete:
+		if t := api.find(id); t != nil {
+			t.cancel()
+			writeJSON(w, http.StatusOK, t.snapshot())
+			return
+		}
+		writeError(w, http.StatusNotFound, "test not found: "+id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+	}
+}
+
+func (api *restAPI) start(w http.ResponseWriter, r *http.Request) {
+	var spec TestSpec
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if errDecode := dec.Decode(&spec); errDecode != nil {
+		writeError(w, http.StatusBadRequest, "bad test spec: "+errDecode.Error())
+		return
+	}
+
+	cfg, errSpec := spec.config(api.app)
+	if errSpec != nil {
+		writeError(w, http.StatusBadRequest, "bad test spec: "+errSpec.Error())
+		return
+	}
+
+	api.mutex.Lock()
+	if running := api.running(); running >= restMaxTests {
+		api.mutex.Unlock()
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("too many running tests: %d", running))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(api.ctx, restMaxDuration+stopGrace)
+	t := &restTest{
+		cancel: cancel,
+		status: TestStatus{State: TestRunning, Spec: spec, Started: time.Now()},
+		live:   map[Stream]*StreamProgress{},
+	}
+	cfg.Observers = []Observer{t}
+
+	api.seq++
+	t.seq = api.seq
+	t.status.ID = strconv.Itoa(api.seq)
+	api.tests[t.status.ID] = t
+	api.expire()
+	api.mutex.Unlock()
+
+	slog.Info("restAPI: starting test", "id", t.status.ID, "remote", r.RemoteAddr, "hosts", spec.Hosts)
+
+	api.wg.Add(1)
+	go func() {
+		defer api.wg.Done()
+		defer cancel()
+		result, errRun := NewClient(cfg).Run(ctx)
+		t.finish(result, errRun)
+		slog.Info("restAPI: test finished", "id", t.status.ID, "err", errRun)
+	}()
+
+	w.Header().Set("Location", "/tests/"+t.status.ID)
+	writeJSON(w, http.StatusCreated, t.snapshot())
+}
+
+// running counts tests still running. Caller holds mutex.
+func (api *restAPI) running() int {
+	var count int
+	for _, t := range api.tests {
+		if t.snapshot().State == TestRunning {
+			count++
+		}
+	}
+	return count
+}
+
+// expire forgets oldest finished tests beyond restHistory. Caller holds mutex.
+func (api *restAPI) expire() {
+	var finished []*restTest
+	for _, t := range api.tests {
+		if t.snapshot().State != TestRunning {
+			finished = append(finished, t)
+		}
+	}
+	if len(finished) <= restHistory {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].seq < finished[j].seq })
+	for _, t := range finished[:len(finished)-restHistory] {
+		delete(api.tests, t.status.ID)
+	}
+}
+
+func (api *restAPI) find(id string) *restTest {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return api.tests[id]
+}
+
+func (api *restAPI) list(w http.ResponseWriter) {
+	api.mutex.Lock()
+	var tests []*restTest
+	for _, t := range api.tests {
+		tests = append(tests, t)
+	}
+	api.mutex.Unlock()
+
+	sort.Slice(tests, func(i, j int) bool { return tests[i].seq < tests[j].seq })
+
+	list := []TestStatus{}
+	for _, t := range tests {
+		list = append(list, t.snapshot())
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if errEncode := enc.Encode(v); errEncode != nil {
+		slog.Error("restAPI: encoding response", "err", errEncode)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
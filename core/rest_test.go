@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestAPI(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Api:            "127.0.0.1:0",
+		AgentSecret:    testSecret,
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+	base := "http://" + server.APIAddr().String()
+
+	spec := `{"hosts":["` + host + `"],"connections":2,"bytes":"1MB","reportInterval":"100ms","readSize":10000,"writeSize":10000}`
+	resp, errPost := apiRequest(http.MethodPost, base+"/tests", spec)
+	if errPost != nil {
+		t.Fatalf("post: %v", errPost)
+	}
+	var st TestStatus
+	errDecode := json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if errDecode != nil {
+		t.Fatalf("post: decode: %v", errDecode)
+	}
+	if resp.StatusCode != http.StatusCreated || st.ID == "" || resp.Header.Get("Location") != "/tests/"+st.ID {
+		t.Fatalf("post: status=%d id=%q location=%q", resp.StatusCode, st.ID, resp.Header.Get("Location"))
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for st.State == TestRunning && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		st = getStatus(t, base+"/tests/"+st.ID)
+	}
+
+	if st.State != TestDone {
+		t.Fatalf("state=%s error=%s", st.State, st.Error)
+	}
+	if st.Result == nil || len(st.Result.Connections) != 2 {
+		t.Fatalf("result: %v", st.Result)
+	}
+	for _, c := range st.Result.Connections {
+		if c.InputBytes != 1000000 || c.OutputBytes != 1000000 || c.Error != "" {
+			t.Errorf("connection %d: input=%d output=%d error=%s", c.Index, c.InputBytes, c.OutputBytes, c.Error)
+		}
+	}
+	if len(st.Progress) != 4 {
+		t.Errorf("progress streams=%d wanted=4", len(st.Progress))
+	}
+	for _, p := range st.Progress {
+		if !p.Done || p.Bytes != 1000000 {
+			t.Errorf("progress %s %s: done=%v bytes=%d", p.Conn, p.Stream, p.Done, p.Bytes)
+		}
+	}
+}
+
+func TestRestAPIErrors(t *testing.T) {
+	api := newRestAPI(context.Background(), nil, &Config{DefaultPort: ":8080", AgentSecret: testSecret})
+
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/tests", `{"hosts":[]}`, http.StatusBadRequest},
+		{"POST", "/tests", `{"hosts":["h"],"totalDuration":"1h"}`, http.StatusBadRequest},
+		{"POST", "/tests", `{"hosts":["h"],"totalDuration":"0"}`, http.StatusBadRequest},
+		{"POST", "/tests", `{"hosts":["h"],"bytes":"lots"}`, http.StatusBadRequest},
+		{"POST", "/tests", `{"hosts":["h"],"bogus":1}`, http.StatusBadRequest},
+		{"GET", "/tests/42", "", http.StatusNotFound},
+		{"DELETE", "/tests/42", "", http.StatusNotFound},
+		{"PUT", "/tests", "", http.StatusMethodNotAllowed},
+		{"GET", "/other", "", http.StatusNotFound},
+		{"GET", "/tests", "", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		r.Header.Set("Authorization", "Bearer "+testSecret)
+		api.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s %s %s: status=%d wanted=%d", c.method, c.path, c.body, w.Code, c.status)
+		}
+	}
+}
+
+func TestRestAPIAuth(t *testing.T) {
+	api := newRestAPI(context.Background(), nil, &Config{DefaultPort: ":8080", AgentSecret: testSecret})
+
+	for _, auth := range []string{"", "Bearer", "Bearer wrong", "Basic " + testSecret} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/tests", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		api.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("authorization %q: status=%d wanted=%d", auth, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if err := NewServer(&Config{Listeners: HostList{"127.0.0.1:0"}, Api: "127.0.0.1:0"}).Start(context.Background()); err == nil {
+		t.Errorf("server started REST API without secret")
+	}
+}
+
+func TestRestAPIMaxTests(t *testing.T) {
+	api := newRestAPI(context.Background(), nil, &Config{DefaultPort: ":8080", AgentSecret: testSecret})
+	for i := 0; i < restMaxTests; i++ {
+		id := strconv.Itoa(i)
+		api.tests[id] = &restTest{status: TestStatus{ID: id, State: TestRunning}}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/tests", strings.NewReader(`{"hosts":["h"]}`))
+	r.Header.Set("Authorization", "Bearer "+testSecret)
+	api.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status=%d wanted=%d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+// apiRequest sends HTTP API request authenticated by test secret.
+func apiRequest(method, url, body string) (*http.Response, error) {
+	req, errReq := http.NewRequest(method, url, strings.NewReader(body))
+	if errReq != nil {
+		return nil, errReq
+	}
+	req.Header.Set("Authorization", "Bearer "+testSecret)
+	return http.DefaultClient.Do(req)
+}
+
+func getStatus(t *testing.T, url string) TestStatus {
+	resp, errGet := apiRequest(http.MethodGet, url, "")
+	if errGet != nil {
+		t.Fatalf("get: %v", errGet)
+	}
+	defer resp.Body.Close()
+	var st TestStatus
+	if errDecode := json.NewDecoder(resp.Body).Decode(&st); errDecode != nil {
+		t.Fatalf("get: decode: %v", errDecode)
+	}
+	return st
+}
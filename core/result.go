@@ -0,0 +1,93 @@
+package core
+
+import (
+	"time"
+)
+
+// Result records client test results.
+type Result struct {
+	Connections []*ConnectionResult
+	Reading     Aggregate // sum over all connections
+	Writing     Aggregate // sum over all connections
+}
+
+// Aggregate records rates summed over connections.
+type Aggregate struct {
+	Mbps int64 // Megabit/s
+	Cps  int64 // Call/s
+}
+
+// ConnectionResult records results for one parallel connection to a host.
+type ConnectionResult struct {
+	Host    string // hostname:port
+	Remote  string // peer address, empty if dial failed
+	Index   int    // parallel connection index to host
+	TLS     bool
+	Proto   string   // TCP, TLS or UDP, empty if dial failed
+	TLSInfo *TLSInfo // negotiated TLS parameters, nil without TLS
+	Input   StreamResult
+	Output  StreamResult
+	Final   *FinalCounters // server final counters, nil if not received
+	Err     error          // connection failure, nil on success
+}
+
+// TLSInfo records negotiated TLS parameters of a connection.
+type TLSInfo struct {
+	Version     string        `json:"version" yaml:"version"`
+	CipherSuite string        `json:"cipherSuite" yaml:"ciphersuite"`
+	ALPN        string        `json:"alpn,omitempty" yaml:"alpn,omitempty"`
+	Handshake   time.Duration `json:"handshakeNs" yaml:"handshake"` // handshake duration
+}
+
+// StreamResult records results for one direction of a connection.
+type StreamResult struct {
+	Bytes    int64         // total bytes transferred
+	Mbps     int64         // average Megabit/s, omitted period excluded
+	Cps      int64         // average Call/s, omitted period excluded
+	Duration time.Duration // time spent transferring
+	Chart    ChartData     // periodic rates
+	Err      error         // stream failure, nil if it ended normally
+
+	// payload verification by the receiving side, zero without Options.Verify
+	Corrupted       int64 // bytes not matching the pattern
+	CorruptedOffset int64 // stream offset of first corrupted byte, valid if Corrupted > 0
+}
+
+func (c *ConnectionResult) exportInfo() ExportInfo {
+	return ExportInfo{Input: c.Input.Chart, Output: c.Output.Chart, Proto: c.Proto, TLS: c.TLSInfo,
+		InputCorrupted: c.Input.Corrupted, OutputCorrupted: c.Output.Corrupted}
+}
+
+// Failed counts connections that failed to establish or ended early.
+func (r *Result) Failed() int {
+	var count int
+	for _, c := range r.Connections {
+		if c.Err != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// reportFailures logs failed connections per host, with the last error.
+func (r *Result) reportFailures() {
+	var hosts []string
+	total := map[string]int{}
+	failed := map[string]int{}
+	lastErr := map[string]error{}
+	for _, c := range r.Connections {
+		if _, seen := total[c.Host]; !seen {
+			hosts = append(hosts, c.Host)
+		}
+		total[c.Host]++
+		if c.Err != nil {
+			failed[c.Host]++
+			lastErr[c.Host] = c.Err
+		}
+	}
+	for _, h := range hosts {
+		if failed[h] > 0 {
+			logResult("failed", "host", h, "connections", failed[h], "total", total[h], "err", lastErr[h])
+		}
+	}
+}
@@ -1,34 +1,11 @@
 package core
 
 import (
-	"log"
+	"crypto/tls"
+	"net"
 	"os"
-	"sync"
 )
 
-func Serve(app *Config) {
-
-	if app.Tls && !fileExists(app.TlsKey) {
-		log.Printf("key file not found: %s - disabling TLS", app.TlsKey)
-		app.Tls = false
-	}
-
-	if app.Tls && !fileExists(app.TlsCert) {
-		log.Printf("cert file not found: %s - disabling TLS", app.TlsCert)
-		app.Tls = false
-	}
-
-	var wg sync.WaitGroup
-
-	for _, h := range app.Listeners {
-		hh := appendPortIfMissing(h, app.DefaultPort)
-		ListenTCP(app, &wg, hh)
-		ListenUDP(app, &wg, hh)
-	}
-
-	wg.Wait()
-}
-
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -55,6 +32,17 @@ LOOP:
 	return host + port
 }
 
+// connProto labels conn protocol as TCP, TLS or UDP.
+func connProto(conn net.Conn) string {
+	switch conn.(type) {
+	case *tls.Conn:
+		return "TLS"
+	case *net.UDPConn:
+		return "UDP"
+	}
+	return "TCP"
+}
+
 func protoLabel(isTLS bool) string {
 	if isTLS {
 		return "TLS"
@@ -1,9 +1,30 @@
 package core
 
 import (
+	"context"
+	"net"
 	"testing"
 )
 
+// testOptions are connection options shared by tests.
+var testOptions = Options{ReadSize: 10000, WriteSize: 10000}
+
+// startServer starts server for app, returning its TCP listener address.
+func startServer(t *testing.T, app *Config) (*Server, string) {
+	server := NewServer(app)
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	for _, addr := range server.Addrs() {
+		if _, ok := addr.(*net.TCPAddr); ok {
+			return server, addr.String()
+		}
+	}
+	server.Shutdown()
+	t.Fatalf("server: no TCP listener")
+	return nil, ""
+}
+
 func TestAppendPort(t *testing.T) {
 	expectAppendPort(t, "", "", "")
 	expectAppendPort(t, "", ":80", ":80")
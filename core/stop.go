@@ -0,0 +1,89 @@
+package core
+
+import (
+	"io"
+	"time"
+)
+
+// End of test protocol for TCP/TLS:
+//
+// 1. client stops writing and half-closes its side of the connection
+// 2. server reads until EOF, then stops writing
+// 3. server sends its final counters as a fixed-size trailer and closes
+// 4. client reads until EOF, the last bytes received are the trailer
+
+// stopGrace bounds how long one side waits for the peer to finish.
+const stopGrace = 10 * time.Second
+
+// streamState reports reader/writer exit to the connection handler.
+// The handler reports stream result to observers once the end of test
+// protocol has completed it.
+type streamState struct {
+	done   chan struct{}
+	stream *Stream      // nil if stream did not run
+	result StreamResult // valid after done is closed
+}
+
+func newStreamState() *streamState {
+	return &streamState{done: make(chan struct{})}
+}
+
+// finish records stream result and signals exit.
+func (s *streamState) finish(result StreamResult) {
+	s.result = result
+	close(s.done)
+}
+
+// report sends final stream result to obs, unless stream did not run.
+func (s *streamState) report(obs Observer) {
+	if s.stream != nil {
+		obs.StreamDone(*s.stream, s.result)
+	}
+}
+
+// trailerReader holds back the last finalSize bytes of the stream,
+// which are the final counters trailer once EOF is reached.
+type trailerReader struct {
+	r       io.Reader
+	buf     []byte
+	hold    int // held bytes at start of buf
+	trailer []byte
+}
+
+func newTrailerReader(r io.Reader) *trailerReader {
+	return &trailerReader{r: r}
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	size := finalSize + len(p)
+	if len(t.buf) < size {
+		buf := make([]byte, size)
+		copy(buf, t.buf[:t.hold])
+		t.buf = buf
+	}
+
+	for {
+		n, err := t.r.Read(t.buf[t.hold : t.hold+len(p)])
+		total := t.hold + n
+
+		deliver := total - finalSize
+		if deliver < 0 {
+			deliver = 0
+		}
+		copy(p, t.buf[:deliver])
+		t.hold = copy(t.buf, t.buf[deliver:total])
+
+		if err == io.EOF {
+			t.trailer = append([]byte{}, t.buf[:t.hold]...)
+		}
+
+		if deliver > 0 || err != nil {
+			return deliver, err
+		}
+	}
+}
+
+// drain discards input until EOF or read deadline.
+func drain(r io.Reader) (int64, error) {
+	return io.Copy(io.Discard, r)
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTrailerReader(t *testing.T) {
+	payload := bytes.Repeat([]byte("goben"), 10000)
+	fin := FinalCounters{ReadBytes: 123, WriteBytes: int64(len(payload))}
+	stream := append(append([]byte{}, payload...), fin.encode()...)
+
+	tr := newTrailerReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	var received bytes.Buffer
+	if _, err := io.CopyBuffer(&received, tr, make([]byte, 7)); err != nil {
+		t.Errorf("copy: %v", err)
+	}
+
+	if !bytes.Equal(received.Bytes(), payload) {
+		t.Errorf("payload mismatch: received=%d wanted=%d", received.Len(), len(payload))
+	}
+
+	got, errFinal := decodeFinal(tr.trailer)
+	if errFinal != nil {
+		t.Errorf("decodeFinal: %v", errFinal)
+	}
+	if got != fin {
+		t.Errorf("final=%v wanted=%v", got, fin)
+	}
+}
+
+func TestTrailerMissing(t *testing.T) {
+	tr := newTrailerReader(bytes.NewReader([]byte("short")))
+	io.Copy(&bytes.Buffer{}, tr)
+	if _, err := decodeFinal(tr.trailer); err == nil {
+		t.Errorf("expected error for missing trailer")
+	}
+}
@@ -1,56 +1,105 @@
 package core
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/gob"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"os"
 	"sync"
 	"time"
 )
 
-func ListenTCP(app *Config, wg *sync.WaitGroup, h string) {
-	log.Printf("listenTCP: TLS=%v spawning TCP listener: %s", app.Tls, h)
+// ListenTCP spawns TCP listener on h, returning its bound address.
+// Under TLS mode optional, the listener serves both TLS and plain TCP.
+func ListenTCP(ctx context.Context, app *Config, wg *sync.WaitGroup, h string) (net.Addr, error) {
+	slog.Info("listenTCP: spawning TCP listener", "tls", app.TlsMode, "addr", h)
 
-	// first try TLS
+	var tlsConf *tls.Config
 	if app.Tls {
-		listener, errTLS := listenTLS(app, h)
-		if errTLS == nil {
-			spawnAcceptLoopTCP(app, wg, listener, true)
-			return
+		conf, errTLS := serverTLSConfig(app)
+		switch {
+		case errTLS == nil:
+			tlsConf = conf
+		case app.TlsMode == TLSRequired:
+			// never serve plain TCP in place of required TLS
+			return nil, fmt.Errorf("listenTCP: TLS required: %v", errTLS)
+		default:
+			slog.Warn("listenTCP: TLS unavailable, serving plain TCP", "err", errTLS)
 		}
-		log.Printf("listenTLS: %v", errTLS)
-		// TLS failed, try plain TCP
 	}
 
 	listener, errListen := net.Listen("tcp", h)
 	if errListen != nil {
-		log.Printf("listenTCP: TLS=%v %s: %v", app.Tls, h, errListen)
-		return
+		return nil, fmt.Errorf("listenTCP: TLS=%s %s: %v", app.TlsMode, h, errListen)
 	}
-	spawnAcceptLoopTCP(app, wg, listener, false)
+	spawnAcceptLoopTCP(ctx, app, wg, listener, tlsConf)
+	return listener.Addr(), nil
 }
 
-func spawnAcceptLoopTCP(app *Config, wg *sync.WaitGroup, listener net.Listener, isTLS bool) {
+func spawnAcceptLoopTCP(ctx context.Context, app *Config, wg *sync.WaitGroup, listener net.Listener, tlsConf *tls.Config) {
 	wg.Add(1)
-	go handleTCP(app, wg, listener, isTLS)
+	go handleTCP(ctx, app, wg, listener, tlsConf)
 }
 
-func listenTLS(app *Config, h string) (net.Listener, error) {
+// serverTLSConfig loads server certificate, unless generated by -selfSigned.
+func serverTLSConfig(app *Config) (*tls.Config, error) {
+	if app.tlsServerCert != nil {
+		return app.tlsServerConfig(*app.tlsServerCert), nil
+	}
+
 	cert, errCert := tls.LoadX509KeyPair(app.TlsCert, app.TlsKey)
 	if errCert != nil {
-		log.Printf("listenTLS: failure loading TLS key pair: %v", errCert)
-		app.Tls = false // disable TLS
+		slog.Error("serverTLSConfig: failure loading TLS key pair", "err", errCert)
 		return nil, errCert
 	}
 
-	config := &tls.Config{Certificates: []tls.Certificate{cert}}
-	listener, errListen := tls.Listen("tcp", h, config)
-	return listener, errListen
+	return app.tlsServerConfig(cert), nil
 }
 
-func handleTCP(app *Config, wg *sync.WaitGroup, listener net.Listener, isTLS bool) {
+// acceptTLS wraps accepted conn in TLS. If TLS is optional, the
+// first byte tells TLS from plain TCP: TLS records start with the
+// handshake content type 0x16, while goben plain TCP clients start
+// with a gob message much longer than 0x16 bytes.
+func acceptTLS(conn net.Conn, tlsConf *tls.Config, required bool) (net.Conn, bool, error) {
+	if tlsConf == nil {
+		return conn, false, nil
+	}
+	if required {
+		return tls.Server(conn, tlsConf), true, nil
+	}
+
+	// like options, first byte has no deadline: client holds established
+	// connections silent until all of them are ready
+	peek := &peekConn{Conn: conn, r: bufio.NewReader(conn)}
+	first, errPeek := peek.r.Peek(1)
+	if errPeek != nil {
+		return nil, false, errPeek
+	}
+	if first[0] == tlsRecordHandshake {
+		return tls.Server(peek, tlsConf), true, nil
+	}
+	return peek, false, nil
+}
+
+// tlsRecordHandshake is the content type of the TLS ClientHello record.
+const tlsRecordHandshake = 0x16
+
+// peekConn replays bytes peeked from Conn.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func handleTCP(ctx context.Context, app *Config, wg *sync.WaitGroup, listener net.Listener, tlsConf *tls.Config) {
 	defer wg.Done()
 
 	var id int
@@ -58,78 +107,259 @@ func handleTCP(app *Config, wg *sync.WaitGroup, listener net.Listener, isTLS boo
 	var aggReader aggregate
 	var aggWriter aggregate
 
+	obs := app.observer()
+
+	var wgConn sync.WaitGroup
+	defer wgConn.Wait()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close() // break accept loop
+	}()
+
 	for {
 		conn, errAccept := listener.Accept()
 		if errAccept != nil {
-			log.Printf("handle: accept: %v", errAccept)
+			if ctx.Err() == nil {
+				slog.Error("handle: accept", "err", errAccept)
+			}
 			break
 		}
-		go handleConnection(conn, id, 0, isTLS, &aggReader, &aggWriter)
+		wgConn.Add(1)
+		go func(conn net.Conn, c int) {
+			defer wgConn.Done()
+			accepted, isTLS, errSniff := acceptTLS(conn, tlsConf, app.TlsMode == TLSRequired)
+			if errSniff == io.EOF {
+				// connection rate tests and health checks close without sending
+				slog.Debug("handle: closed before sending", "remote", conn.RemoteAddr())
+				conn.Close()
+				return
+			}
+			if errSniff != nil {
+				slog.Error("handle: detecting TLS", "remote", conn.RemoteAddr(), "err", errSniff)
+				conn.Close()
+				return
+			}
+			app.metrics.sessionStart(protoLabel(isTLS))
+			defer app.metrics.sessionEnd(protoLabel(isTLS))
+			handleConnection(ctx, app, accepted, c, 0, isTLS, &aggReader, &aggWriter, obs)
+		}(conn, id)
 		id++
 	}
 }
 
-func handleConnection(conn net.Conn, c, connections int, isTLS bool, aggReader, aggWriter *aggregate) {
+func handleConnection(ctx context.Context, app *Config, conn net.Conn, c, connections int, isTLS bool, aggReader, aggWriter *aggregate, obs Observer) {
 	defer conn.Close()
 
-	log.Printf("handleConnection: incoming: %s %v", protoLabel(isTLS), conn.RemoteAddr())
+	metrics := app.metrics
+
+	proto := protoLabel(isTLS)
+	slog.Info("handleConnection: incoming", "proto", proto, "remote", conn.RemoteAddr())
+
+	var tlsInfo *TLSInfo
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake explicitly in order to tell its failures apart
+		conn.SetDeadline(time.Now().Add(stopGrace))
+		start := time.Now()
+		if errHandshake := tlsConn.Handshake(); errHandshake != nil {
+			slog.Error("handleConnection: TLS handshake", "remote", conn.RemoteAddr(), "err", errHandshake)
+			metrics.handshakeFailure(proto)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		tlsInfo = newTLSInfo(tlsConn.ConnectionState(), time.Since(start))
+	}
 
 	// receive options
 	var opt Options
-	dec := gob.NewDecoder(conn)
+	dec := gob.NewDecoder(exactReader{conn})
 	if errOpt := dec.Decode(&opt); errOpt != nil {
-		log.Printf("handleConnection: options failure: %v", errOpt)
+		if errOpt == io.EOF {
+			// connection rate tests and health checks close without sending
+			slog.Debug("handleConnection: closed without options", "remote", conn.RemoteAddr())
+			return
+		}
+		slog.Error("handleConnection: options failure", "err", errOpt)
+		metrics.handshakeFailure(proto)
 		return
 	}
-	log.Printf("handleConnection: options received: %v", opt)
+	slog.Debug("handleConnection: options received", "options", opt)
 
 	// send ack
 	a := newAck()
+	if opt.Control {
+		a.Table = map[string]string{controlNonce: newNonce()}
+	}
 	if errAck := ackSend(false, conn, a); errAck != nil {
-		log.Printf("handleConnection: sending ack: %v", errAck)
+		slog.Error("handleConnection: sending ack", "err", errAck)
+		metrics.handshakeFailure(proto)
+		return
+	}
+
+	if opt.Control {
+		handleControl(ctx, app, conn, a.Table[controlNonce])
+		return
+	}
+
+	if opt.Short {
+		// client only measures connection establishment
+		if opt.Echo > 0 {
+			conn.SetDeadline(time.Now().Add(stopGrace))
+			if _, errEcho := io.CopyN(conn, conn, int64(opt.Echo)); errEcho != nil {
+				slog.Error("handleConnection: echo", "remote", conn.RemoteAddr(), "err", errEcho)
+			}
+		}
 		return
 	}
 
-	go serverReader(conn, opt, c, connections, isTLS, aggReader)
+	if tlsInfo != nil {
+		logTLS(tlsInfo, "remote", conn.RemoteAddr())
+	}
 
-	if !opt.PassiveServer {
-		go serverWriter(conn, opt, c, connections, isTLS, aggWriter)
+	if opt.TotalDuration > 0 && opt.limit() == 0 {
+		// client finishes the test, deadline only protects from vanished clients.
+		// size-limited test runs to completion regardless of duration.
+		conn.SetDeadline(time.Now().Add(opt.TotalDuration + stopGrace))
 	}
 
-	tickerPeriod := time.NewTimer(opt.TotalDuration)
+	// on server shutdown, stop reading at once and bound sending final counters
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+			conn.SetWriteDeadline(time.Now().Add(stopGrace))
+		case <-finished:
+		}
+	}()
+
+	rs := newStreamState()
+	ws := newStreamState()
+
+	ctxWriter, cancelWriter := context.WithCancel(ctx)
+	defer cancelWriter()
 
-	<-tickerPeriod.C
-	log.Printf("handleConnection: %v timer", opt.TotalDuration)
+	go serverReader(ctx, conn, opt, c, connections, isTLS, app.RecvFile, rs, aggReader, obs)
 
-	tickerPeriod.Stop()
+	if opt.PassiveServer {
+		ws.finish(StreamResult{})
+	} else {
+		go serverWriter(ctxWriter, conn, opt, c, connections, isTLS, ws, aggWriter, obs)
+	}
+
+	finishServer(ctx, conn, opt, fmt.Sprintf("%d/%d", c, connections), rs, ws, cancelWriter, obs)
+
+	slog.Info("handleConnection: closing", "remote", conn.RemoteAddr())
+}
 
-	log.Printf("handleConnection: closing: %v", conn.RemoteAddr())
+// finishServer performs server side of the end of test protocol.
+func finishServer(ctx context.Context, conn net.Conn, opt Options, connIndex string, rs, ws *streamState, stopWriter context.CancelFunc, obs Observer) {
+	<-rs.done
+
+	// reader might have stopped early on transfer limit, wait client EOF
+	extra, errDrain := drain(conn)
+	if errDrain != nil && ctx.Err() == nil {
+		slog.Error("handleConnection: draining", "conn", connIndex, "err", errDrain)
+	}
+
+	// size-limited writer runs to completion
+	if opt.limit() == 0 {
+		stopWriter()
+	}
+	<-ws.done
+
+	rs.result.Bytes += extra
+	rs.report(obs)
+	ws.report(obs)
+
+	fin := FinalCounters{ReadBytes: rs.result.Bytes, WriteBytes: ws.result.Bytes,
+		ReadCorrupted: rs.result.Corrupted, ReadCorruptedOffset: rs.result.CorruptedOffset}
+	if _, errFinal := conn.Write(fin.encode()); errFinal != nil {
+		slog.Error("handleConnection: sending final counters", "conn", connIndex, "err", errFinal)
+		return
+	}
+	logResult("final", "conn", connIndex, "received", fin.ReadBytes, "sent", fin.WriteBytes)
 }
 
-func serverReader(conn net.Conn, opt Options, c, connections int, isTLS bool, agg *aggregate) {
+func serverReader(ctx context.Context, conn net.Conn, opt Options, c, connections int, isTLS bool, recvFile string, rs *streamState, agg *aggregate, obs Observer) {
+	var result StreamResult
+	defer func() { rs.finish(result) }()
 
-	log.Printf("serverReader: starting: %s %v", protoLabel(isTLS), conn.RemoteAddr())
+	slog.Debug("serverReader: starting", "proto", protoLabel(isTLS), "remote", conn.RemoteAddr())
 
 	connIndex := fmt.Sprintf("%d/%d", c, connections)
 
 	buf := make([]byte, opt.ReadSize)
 
-	workLoop(connIndex, "serverReader", "rcv/s", conn.Read, buf, opt.ReportInterval, 0, nil, agg)
+	var file *os.File
+	var sink io.Writer // nil unless receiving to file
+	var fs fileStats
+	var filename string
+	if recvFile != "" {
+		filename = recvFilename(recvFile, c, conn.RemoteAddr())
+		var errCreate error
+		file, errCreate = os.Create(filename)
+		if errCreate != nil {
+			slog.Error("serverReader: create", "conn", connIndex, "err", errCreate)
+			return
+		}
+		defer file.Close()
+		sink = file
+	}
 
-	log.Printf("serverReader: exiting: %v", conn.RemoteAddr())
-}
+	read, verify := serverReadCall(conn, opt, sink, &fs)
+
+	s := Stream{Conn: connIndex, Remote: conn.RemoteAddr().String(), Proto: protoLabel(isTLS), Label: "serverReader", Input: true}
+	rs.stream = &s
+	result = workLoop(ctx, s, read, buf, opt.ReportInterval, opt.Omit, 0, nil, agg, obs)
+
+	if verify != nil {
+		verify.report(connIndex, "serverReader")
+		verify.record(&result)
+	}
+
+	if file != nil {
+		fs.report(connIndex, "serverReader", filename)
+	}
 
+	slog.Debug("serverReader: exiting", "remote", conn.RemoteAddr())
+}
 
+// serverReadCall builds reader chain: payload is verified, then
+// written to file, if either is enabled. Nil file disables writing.
+func serverReadCall(conn io.Reader, opt Options, file io.Writer, fs *fileStats) (call, *verifyReader) {
+	read := call(conn.Read)
+	var verify *verifyReader
+	if opt.Verify {
+		verify = newVerifyReader(conn, opt, false)
+		read = verify.read
+	}
+	if file != nil {
+		read = fileReceiver(read, file, fs)
+	}
+	return limitCall(read, opt.limit()), verify
+}
 
-func serverWriter(conn net.Conn, opt Options, c, connections int, isTLS bool, agg *aggregate) {
+func serverWriter(ctx context.Context, conn net.Conn, opt Options, c, connections int, isTLS bool, ws *streamState, agg *aggregate, obs Observer) {
 
-	log.Printf("serverWriter: starting: %s %v", protoLabel(isTLS), conn.RemoteAddr())
+	slog.Debug("serverWriter: starting", "proto", protoLabel(isTLS), "remote", conn.RemoteAddr())
 
 	connIndex := fmt.Sprintf("%d/%d", c, connections)
 
 	buf := randBuf(opt.WriteSize)
 
-	workLoop(connIndex, "serverWriter", "snd/s", conn.Write, buf, opt.ReportInterval, opt.MaxSpeed, nil, agg)
+	write := conn.Write
+	if opt.Verify {
+		write = newVerifyWriter(conn, opt, false).write
+	}
+	write = limitCall(write, opt.limit())
+
+	s := Stream{Conn: connIndex, Remote: conn.RemoteAddr().String(), Proto: protoLabel(isTLS), Label: "serverWriter"}
+	ws.stream = &s
+	result := workLoop(ctx, s, write, buf, opt.ReportInterval, opt.Omit, opt.MaxSpeed, retransCounter(conn), agg, obs)
+
+	ws.finish(result)
 
-	log.Printf("serverWriter: exiting: %v", conn.RemoteAddr())
+	slog.Debug("serverWriter: exiting", "remote", conn.RemoteAddr())
 }
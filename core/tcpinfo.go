@@ -0,0 +1,30 @@
+package core
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// tcpConn finds TCP connection beneath conn.
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if peek, ok := conn.(*peekConn); ok {
+		conn = peek.Conn
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	return tcp, ok
+}
+
+// retransCounter returns function reporting retransmitted segments on
+// conn, nil if unavailable.
+func retransCounter(conn net.Conn) func() int64 {
+	if _, ok := tcpRetransmits(conn); !ok {
+		return nil
+	}
+	return func() int64 {
+		n, _ := tcpRetransmits(conn)
+		return n
+	}
+}
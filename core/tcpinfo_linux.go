@@ -0,0 +1,35 @@
+package core
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// retransSupported tells whether tcpRetransmits is available.
+const retransSupported = true
+
+// tcpRetransmits returns segments retransmitted on TCP or TLS connection.
+func tcpRetransmits(conn net.Conn) (int64, bool) {
+	tcp, ok := tcpConn(conn)
+	if !ok {
+		return 0, false
+	}
+	raw, errRaw := tcp.SyscallConn()
+	if errRaw != nil {
+		return 0, false
+	}
+
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	errControl := raw.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if errControl != nil || errno != 0 {
+		return 0, false
+	}
+
+	return int64(info.Total_retrans), true
+}
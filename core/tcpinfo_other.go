@@ -0,0 +1,15 @@
+//go:build !linux
+
+package core
+
+import (
+	"net"
+)
+
+// retransSupported tells whether tcpRetransmits is available.
+const retransSupported = false
+
+// tcpRetransmits is not supported on this platform.
+func tcpRetransmits(conn net.Conn) (int64, bool) {
+	return 0, false
+}
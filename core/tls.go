@@ -0,0 +1,288 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is the lifetime of generated certificates.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// loadCertPool reads PEM certificates from file.
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	buf, errRead := os.ReadFile(filename)
+	if errRead != nil {
+		return nil, errRead
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", filename)
+	}
+	return pool, nil
+}
+
+// generateCert creates in-memory self-signed certificate for this host.
+func generateCert() (tls.Certificate, error) {
+	key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		return tls.Certificate{}, errKey
+	}
+
+	serial, errSerial := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if errSerial != nil {
+		return tls.Certificate{}, errSerial
+	}
+
+	names := []string{"localhost"}
+	if hostname, errHost := os.Hostname(); errHost == nil && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[len(names)-1], Organization: []string{"goben"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, errCert := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if errCert != nil {
+		return tls.Certificate{}, errCert
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Fingerprint returns SHA-256 fingerprint of DER certificate as hex pairs
+// separated by colons.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(pairs, ":")
+}
+
+// parseFingerprint decodes SHA-256 fingerprint, with or without colons
+// and optional "sha256:" prefix.
+func parseFingerprint(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "sha256:")
+	pin, errHex := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if errHex != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("expecting %d hex bytes", sha256.Size)
+	}
+	return pin, nil
+}
+
+// errPinMismatch reports server certificate not matching pinned fingerprint.
+var errPinMismatch = errors.New("server certificate does not match pinned fingerprint")
+
+// verifyPin checks server leaf certificate against pinned fingerprint.
+func verifyPin(pin []byte) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errPinMismatch
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		if !bytes.Equal(sum[:], pin) {
+			return fmt.Errorf("%w: got %s", errPinMismatch, Fingerprint(cs.PeerCertificates[0].Raw))
+		}
+		return nil
+	}
+}
+
+// tlsClientConfig builds client TLS configuration. Without TlsVerify
+// nor TlsPin the server certificate is accepted as is.
+func (app *Config) tlsClientConfig() *tls.Config {
+	conf := &tls.Config{
+		InsecureSkipVerify: !app.TlsVerify,
+		RootCAs:            app.tlsRoots,
+		ServerName:         app.TlsServerName,
+	}
+	if app.tlsClientCert != nil {
+		conf.Certificates = []tls.Certificate{*app.tlsClientCert}
+	}
+	if app.tlsPin != nil {
+		conf.VerifyConnection = verifyPin(app.tlsPin)
+	}
+	conf.ClientSessionCache = app.tlsSessions
+	app.tlsCommon(conf)
+	return conf
+}
+
+// tlsServerConfig builds server TLS configuration for cert. With
+// TlsClientCA, clients must present certificates signed by it.
+func (app *Config) tlsServerConfig(cert tls.Certificate) *tls.Config {
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if app.tlsClientRoots != nil {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conf.ClientCAs = app.tlsClientRoots
+	}
+	app.tlsCommon(conf)
+	return conf
+}
+
+// TLS modes for Config.TlsMode.
+const (
+	TLSRequired = "required" // TCP only over TLS
+	TLSOptional = "optional" // client falls back to plain TCP, server accepts both
+	TLSOff      = "off"      // plain TCP only
+)
+
+// tlsStrict tells whether TLS failures must not fall back to plain TCP,
+// since TLS or server authentication was requested.
+func (app *Config) tlsStrict() bool {
+	return app.TlsMode == TLSRequired || app.TlsVerify || app.TlsPin != ""
+}
+
+// disableTLS switches to plain TCP.
+func (app *Config) disableTLS() {
+	app.TlsMode = TLSOff
+	app.Tls = false
+}
+
+// tlsDialError explains certificate verification failures.
+func tlsDialError(h string, err error) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return fmt.Errorf("TLS %s: server certificate signed by unknown authority (use -ca to trust its CA): %w", h, err)
+	case errors.As(err, &hostname):
+		return fmt.Errorf("TLS %s: server certificate does not match name (use -tlsServerName): %w", h, err)
+	case errors.As(err, &invalid), errors.As(err, &verification):
+		return fmt.Errorf("TLS %s: server certificate verification failed: %w", h, err)
+	}
+	return fmt.Errorf("TLS %s: %w", h, err)
+}
+
+// tlsRejected reports whether err is server certificate rejected by
+// verification or pinning, which retrying would not change.
+func tlsRejected(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &verification) || errors.Is(err, errPinMismatch)
+}
+
+// tlsDial connects to h over TLS, returning handshake duration.
+func tlsDial(ctx context.Context, app *Config, dialer net.Dialer, proto, h string) (*tls.Conn, time.Duration, error) {
+	raw, errDial := dialer.DialContext(ctx, proto, h)
+	if errDial != nil {
+		return nil, 0, errDial
+	}
+
+	conf := app.tlsClientConfig()
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(h)
+	}
+
+	if dialer.Timeout > 0 {
+		// like tls.DialWithDialer, timeout bounds handshake as well
+		raw.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+
+	conn := tls.Client(raw, conf)
+	start := time.Now()
+	if errHandshake := conn.HandshakeContext(ctx); errHandshake != nil {
+		raw.Close()
+		return nil, 0, tlsDialError(h, errHandshake)
+	}
+	handshake := time.Since(start)
+
+	raw.SetDeadline(time.Time{})
+
+	return conn, handshake, nil
+}
+
+func newTLSInfo(cs tls.ConnectionState, handshake time.Duration) *TLSInfo {
+	return &TLSInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		Handshake:   handshake,
+	}
+}
+
+// logTLS reports negotiated TLS parameters.
+func logTLS(info *TLSInfo, args ...any) {
+	logResult("tls", append(args, "version", info.Version, "cipher", info.CipherSuite, "alpn", info.ALPN, "handshake", info.Handshake)...)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses version as 1.0, 1.1, 1.2 or 1.3. Empty means default.
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, found := tlsVersions[strings.TrimPrefix(strings.ToUpper(version), "TLS")]
+	if !found {
+		return 0, fmt.Errorf("expecting 1.0, 1.1, 1.2 or 1.3")
+	}
+	return v, nil
+}
+
+// parseCipherSuites parses comma-separated cipher suite names, such as
+// TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256. Empty means default.
+func parseCipherSuites(list string) ([]uint16, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	known := map[string]*tls.CipherSuite{}
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[cs.Name] = cs
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		cs, found := known[strings.TrimSpace(name)]
+		if !found {
+			return nil, fmt.Errorf("unknown cipher suite: %q", name)
+		}
+		if len(cs.SupportedVersions) == 1 && cs.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS 1.3 cipher suites are not configurable, use tlsMaxVersion 1.2: %q", name)
+		}
+		ids = append(ids, cs.ID)
+	}
+	return ids, nil
+}
+
+// tlsCommon applies version, cipher suite and ALPN restrictions.
+func (app *Config) tlsCommon(conf *tls.Config) {
+	conf.MinVersion = app.tlsMinVersion
+	conf.MaxVersion = app.tlsMaxVersion
+	conf.CipherSuites = app.tlsCipherSuites
+	conf.NextProtos = app.tlsALPN
+}
@@ -0,0 +1,431 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes self-signed certificate for 127.0.0.1 and
+// goben.test into dir, returning cert and key filenames.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		t.Fatalf("key: %v", errKey)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "goben test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"goben.test"},
+	}
+	der, errCert := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if errCert != nil {
+		t.Fatalf("cert: %v", errCert)
+	}
+	keyDer, errMarshal := x509.MarshalECPrivateKey(key)
+	if errMarshal != nil {
+		t.Fatalf("key: %v", errMarshal)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSVerify(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Tls:            true,
+		TlsCert:        certFile,
+		TlsKey:         keyFile,
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	for _, c := range []struct {
+		name       string
+		ca         string
+		serverName string
+		verify     bool
+		wantErr    string
+	}{
+		{"insecure", "", "", false, ""},
+		{"ca", certFile, "", true, ""},
+		{"ca server name", certFile, "goben.test", true, ""},
+		{"unknown authority", "", "", true, "unknown authority"},
+		{"wrong name", certFile, "other.test", true, "does not match name"},
+		{"ca implies verify", certFile, "other.test", false, "does not match name"},
+	} {
+		client := NewClient(&Config{
+			Hosts:          HostList{host},
+			Connections:    1,
+			ReportInterval: "1s",
+			Bytes:          "100KB",
+			Tls:            true,
+			TlsCA:          c.ca,
+			TlsServerName:  c.serverName,
+			TlsVerify:      c.verify,
+			Opt:            testOptions,
+		})
+
+		result, err := client.Run(context.Background())
+		conn := result.Connections[0]
+
+		if c.wantErr == "" {
+			if err != nil || !conn.TLS {
+				t.Errorf("%s: tls=%v err=%v", c.name, conn.TLS, err)
+			}
+			continue
+		}
+
+		if err == nil || conn.TLS || conn.Err == nil || !strings.Contains(conn.Err.Error(), c.wantErr) {
+			t.Errorf("%s: expected %q without plain TCP fallback: tls=%v err=%v", c.name, c.wantErr, conn.TLS, conn.Err)
+		}
+	}
+}
+
+func TestTLSTrustSettings(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _ := writeTestCert(t, dir)
+
+	app := &Config{Connections: 1, ReportInterval: "1s", TlsCA: certFile, TlsNoVerify: true,
+		Opt: Options{ReadSize: 1, WriteSize: 1}}
+	if err := app.Setup(); err != nil || app.TlsVerify {
+		t.Errorf("tlsNoVerify: verify=%v err=%v", app.TlsVerify, err)
+	}
+
+	agent := &Config{DefaultPort: ":8080", TlsCA: certFile, TlsPin: strings.Repeat("ab", 32), TlsServerName: "goben.test"}
+	for name, cfg := range map[string]func() (*Config, error){
+		"control": func() (*Config, error) {
+			cfg := ControlRequest{Host: "h", Connections: 1, Opt: Options{ReadSize: 1, WriteSize: 1, ReportInterval: time.Second}}.config(agent)
+			return cfg, cfg.Setup()
+		},
+		"rest": func() (*Config, error) { return TestSpec{Hosts: []string{"h"}, Tls: true}.config(agent) },
+	} {
+		c, err := cfg()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !c.TlsVerify || c.tlsRoots == nil || c.TlsPin != agent.TlsPin || c.TlsServerName != agent.TlsServerName {
+			t.Errorf("%s: trust settings not inherited: verify=%v pin=%q serverName=%q", name, c.TlsVerify, c.TlsPin, c.TlsServerName)
+		}
+	}
+}
+
+func TestTLSBadCA(t *testing.T) {
+	app := &Config{Connections: 1, ReportInterval: "1s", TlsCA: "/nonexistent/ca.pem",
+		Opt: Options{ReadSize: 1, WriteSize: 1}}
+	if err := app.Setup(); err == nil || !strings.Contains(err.Error(), "bad ca") {
+		t.Errorf("expected bad ca error: %v", err)
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		Tls:            true,
+		TlsCert:        certFile,
+		TlsKey:         keyFile,
+		TlsClientCA:    certFile,
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	for _, addr := range server.Addrs() {
+		if _, ok := addr.(*net.UDPAddr); ok {
+			t.Errorf("unauthenticated UDP listener: %v", addr)
+		}
+	}
+
+	for _, c := range []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{"client cert", certFile, keyFile, false},
+		{"no client cert", "", "", true},
+	} {
+		client := NewClient(&Config{
+			Hosts:          HostList{host},
+			Connections:    1,
+			ReportInterval: "1s",
+			Bytes:          "100KB",
+			Tls:            true,
+			TlsClientCert:  c.cert,
+			TlsClientKey:   c.key,
+			Opt:            testOptions,
+		})
+
+		result, err := client.Run(context.Background())
+		if c.wantErr {
+			if err == nil || result.Connections[0].Err == nil {
+				t.Errorf("%s: expected failure: %v", c.name, err)
+			}
+			continue
+		}
+		if err != nil || !result.Connections[0].TLS {
+			t.Errorf("%s: tls=%v err=%v", c.name, result.Connections[0].TLS, err)
+		}
+	}
+
+	plain := NewServer(&Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		TlsClientCA:    certFile,
+		Opt:            testOptions,
+	})
+	if err := plain.Start(context.Background()); err == nil {
+		plain.Shutdown()
+		t.Errorf("clientCA without TLS: expected error")
+	}
+}
+
+func TestTLSSelfSignedPin(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		TlsSelfSigned:  true,
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	fingerprint := server.TLSFingerprint()
+	if len(fingerprint) != 95 {
+		t.Fatalf("fingerprint: %q", fingerprint)
+	}
+
+	other := strings.Repeat("00:", 31) + "00"
+
+	for _, c := range []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"pin", fingerprint, false},
+		{"pin lowercase without colons", "sha256:" + strings.ToLower(strings.ReplaceAll(fingerprint, ":", "")), false},
+		{"wrong pin", other, true},
+	} {
+		client := NewClient(&Config{
+			Hosts:          HostList{host},
+			Connections:    1,
+			ReportInterval: "1s",
+			Bytes:          "100KB",
+			Tls:            true,
+			TlsPin:         c.pin,
+			DialRetries:    2,
+			Opt:            testOptions,
+		})
+
+		begin := time.Now()
+		result, err := client.Run(context.Background())
+		conn := result.Connections[0]
+		if c.wantErr {
+			if err == nil || conn.TLS || conn.Err == nil || !strings.Contains(conn.Err.Error(), "pinned fingerprint") {
+				t.Errorf("%s: expected pin mismatch without plain TCP fallback: tls=%v err=%v", c.name, conn.TLS, conn.Err)
+			}
+			if elapsed := time.Since(begin); elapsed >= dialRetryDelay {
+				t.Errorf("%s: rejected certificate was retried: elapsed=%v", c.name, elapsed)
+			}
+			continue
+		}
+		if err != nil || !conn.TLS {
+			t.Errorf("%s: tls=%v err=%v", c.name, conn.TLS, err)
+		}
+	}
+
+	bad := &Config{Connections: 1, ReportInterval: "1s", TlsPin: "12:34", Opt: Options{ReadSize: 1, WriteSize: 1}}
+	if err := bad.Setup(); err == nil {
+		t.Errorf("short pin: expected error")
+	}
+}
+
+func TestTLSCipherSelection(t *testing.T) {
+	server, host := startServer(t, &Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		TlsSelfSigned:  true,
+		TlsALPN:        "goben",
+		Opt:            testOptions,
+	})
+	defer server.Shutdown()
+
+	const chacha = "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"
+
+	client := NewClient(&Config{
+		Hosts:          HostList{host},
+		Connections:    1,
+		ReportInterval: "1s",
+		Bytes:          "100KB",
+		Tls:            true,
+		TlsPin:         server.TLSFingerprint(),
+		TlsMaxVersion:  "1.2",
+		TlsCiphers:     chacha,
+		TlsALPN:        "goben",
+		Opt:            testOptions,
+	})
+
+	result, err := client.Run(context.Background())
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	info := result.Connections[0].TLSInfo
+	if info == nil {
+		t.Fatalf("missing TLS info")
+	}
+	if info.Version != "TLS 1.2" || info.CipherSuite != chacha || info.ALPN != "goben" || info.Handshake <= 0 {
+		t.Errorf("unexpected TLS info: %+v", *info)
+	}
+}
+
+func TestTLSBadCipherSettings(t *testing.T) {
+	for _, app := range []Config{
+		{TlsCiphers: "TLS_BOGUS"},
+		{TlsCiphers: "TLS_AES_128_GCM_SHA256"},
+		{TlsMinVersion: "1.4"},
+		{TlsMinVersion: "1.3", TlsMaxVersion: "1.2"},
+	} {
+		app.Connections = 1
+		app.ReportInterval = "1s"
+		app.Opt = Options{ReadSize: 1, WriteSize: 1}
+		if err := app.Setup(); err == nil {
+			t.Errorf("expected error: ciphers=%q min=%q max=%q", app.TlsCiphers, app.TlsMinVersion, app.TlsMaxVersion)
+		}
+	}
+}
+
+func TestTLSModes(t *testing.T) {
+	for _, c := range []struct {
+		server    string
+		client    string
+		wantProto string // empty means failure
+	}{
+		{TLSOptional, TLSOff, "TCP"},
+		{TLSOptional, TLSOptional, "TLS"},
+		{TLSOptional, TLSRequired, "TLS"},
+		{TLSRequired, TLSRequired, "TLS"},
+		{TLSRequired, TLSOff, ""},
+		{TLSOff, TLSOptional, "TCP"},
+		{TLSOff, TLSRequired, ""},
+	} {
+		server, host := startServer(t, &Config{
+			Listeners:      HostList{"127.0.0.1:0"},
+			Connections:    1,
+			ReportInterval: "1s",
+			TlsSelfSigned:  c.server != TLSOff,
+			TlsMode:        c.server,
+			Opt:            testOptions,
+		})
+
+		client := NewClient(&Config{
+			Hosts:          HostList{host},
+			Connections:    1,
+			ReportInterval: "1s",
+			Bytes:          "100KB",
+			TlsMode:        c.client,
+			Opt:            testOptions,
+		})
+		result, err := client.Run(context.Background())
+		server.Shutdown()
+
+		conn := result.Connections[0]
+		if c.wantProto == "" {
+			if err == nil {
+				t.Errorf("server=%s client=%s: expected failure, got proto=%s", c.server, c.client, conn.Proto)
+			}
+			continue
+		}
+		if err != nil || conn.Proto != c.wantProto || conn.TLS != (c.wantProto == "TLS") {
+			t.Errorf("server=%s client=%s: proto=%q tls=%v err=%v, want %s", c.server, c.client, conn.Proto, conn.TLS, err, c.wantProto)
+		}
+	}
+}
+
+func TestTLSRequiredWithoutCert(t *testing.T) {
+	server := NewServer(&Config{
+		Listeners:      HostList{"127.0.0.1:0"},
+		Connections:    1,
+		ReportInterval: "1s",
+		TlsMode:        TLSRequired,
+		TlsCert:        "missing-cert.pem",
+		TlsKey:         "missing-key.pem",
+		Opt:            testOptions,
+	})
+	if err := server.Start(context.Background()); err == nil {
+		server.Shutdown()
+		t.Errorf("expected required TLS without certificate to fail")
+	}
+}
+
+func TestTLSHandshakeCanceled(t *testing.T) {
+	// listener accepts but never answers the handshake
+	ln, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatalf("listen: %v", errListen)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := NewClient(&Config{
+		Hosts:          HostList{ln.Addr().String()},
+		Connections:    1,
+		ReportInterval: "1s",
+		DialTimeout:    "1m",
+		TlsMode:        TLSRequired,
+		Opt:            testOptions,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	client.Run(ctx)
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("run took %v after cancel during handshake", elapsed)
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/guptarohit/asciigraph"
+)
+
+// tuiHistory is the number of aggregate samples plotted by the dashboard.
+const tuiHistory = 60
+
+// tuiObserver draws live dashboard of every stream on a terminal,
+// redrawing the whole screen on every refresh.
+type tuiObserver struct {
+	NopObserver
+	out       io.Writer
+	start     time.Time
+	mutex     sync.Mutex
+	rows      map[Stream]*tuiRow
+	aggregate []float64 // aggregate Mbps on every refresh
+}
+
+// tuiRow is the live state of one stream.
+type tuiRow struct {
+	current float64 // Mbps during last interval
+	average float64 // Mbps since start, final average when done
+	bytes   int64
+	cps     int64
+	retrans int64
+	done    bool
+}
+
+func newTuiObserver(out io.Writer) *tuiObserver {
+	return &tuiObserver{out: out, start: time.Now(), rows: map[Stream]*tuiRow{}}
+}
+
+// run redraws dashboard every interval until returned stop is called.
+func (o *tuiObserver) run(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				o.refresh(false)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (o *tuiObserver) row(s Stream) *tuiRow {
+	r, found := o.rows[s]
+	if !found {
+		r = &tuiRow{}
+		o.rows[s] = r
+	}
+	return r
+}
+
+func (o *tuiObserver) Sample(s Stream, sample Sample) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	r := o.row(s)
+	r.current = sample.Mbps
+	r.bytes += sample.Bytes
+	r.cps = sample.Cps
+	r.retrans = sample.Retransmits
+	if elap := sample.Time.Sub(o.start).Seconds(); elap > 0 {
+		r.average = float64(8*r.bytes) / (1000000 * elap)
+	}
+}
+
+func (o *tuiObserver) StreamDone(s Stream, result StreamResult) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	r := o.row(s)
+	r.current = 0
+	r.average = float64(result.Mbps)
+	r.bytes = result.Bytes
+	r.cps = result.Cps
+	r.done = true
+}
+
+func (o *tuiObserver) TestDone(result *Result) {
+	o.refresh(true)
+}
+
+// refresh records aggregate sample and redraws screen.
+func (o *tuiObserver) refresh(final bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	var read, write float64
+	for s, r := range o.rows {
+		mbps := r.current
+		if final {
+			mbps = r.average
+		}
+		if s.Input {
+			read += mbps
+		} else {
+			write += mbps
+		}
+	}
+	if !final {
+		o.aggregate = append(o.aggregate, read+write)
+		if len(o.aggregate) > tuiHistory {
+			o.aggregate = o.aggregate[len(o.aggregate)-tuiHistory:]
+		}
+	}
+
+	io.WriteString(o.out, "\033[H\033[2J") // cursor home, clear screen
+	o.draw(read, write, final)
+}
+
+// draw renders dashboard. Caller holds mutex.
+func (o *tuiObserver) draw(read, write float64, final bool) {
+	state := "running (Ctrl-C to stop)"
+	if final {
+		state = "finished"
+	}
+	fmt.Fprintf(o.out, "goben %s  elapsed %v  %s\n\n", Version, time.Since(o.start).Round(time.Second), state)
+
+	var streams []Stream
+	for s := range o.rows {
+		streams = append(streams, s)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].Remote != streams[j].Remote {
+			return streams[i].Remote < streams[j].Remote
+		}
+		if streams[i].Conn != streams[j].Conn {
+			return streams[i].Conn < streams[j].Conn
+		}
+		return streams[i].Input && !streams[j].Input
+	})
+
+	tw := tabwriter.NewWriter(o.out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "HOST\tCONN\tDIRECTION\tCURRENT Mbps\tAVG Mbps\tCALLS/s\tRETRANS\tSTATE\t")
+	for _, s := range streams {
+		r := o.rows[s]
+		direction := "write"
+		retrans := "-"
+		if s.Input {
+			direction = "read"
+		} else if retransSupported && s.Proto != "UDP" {
+			retrans = fmt.Sprint(r.retrans)
+		}
+		st := "running"
+		if r.done {
+			st = "done"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.0f\t%.0f\t%d\t%s\t%s\t\n", s.Remote, s.Conn, direction, r.current, r.average, r.cps, retrans, st)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(o.out, "\naggregate: read %.0f Mbps  write %.0f Mbps\n\n", read, write)
+
+	// asciigraph cannot interpolate a single point
+	if len(o.aggregate) > 1 {
+		fmt.Fprintln(o.out, asciigraph.Plot(o.aggregate, asciigraph.Height(8), asciigraph.Width(tuiHistory),
+			asciigraph.Caption("aggregate Mbps (read+write)")))
+	}
+}
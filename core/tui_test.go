@@ -0,0 +1,49 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTuiObserver(t *testing.T) {
+	var buf bytes.Buffer
+	tui := newTuiObserver(&buf)
+
+	reader := Stream{Conn: "0/2", Remote: "10.0.0.1:8080", Proto: "TCP", Label: "clientReader", Input: true}
+	writer := Stream{Conn: "0/2", Remote: "10.0.0.1:8080", Proto: "TCP", Label: "clientWriter"}
+
+	now := tui.start.Add(time.Second)
+	tui.Sample(reader, Sample{Time: now, Bytes: 125000000, Mbps: 1000, Cps: 50})
+	tui.Sample(writer, Sample{Time: now, Bytes: 62500000, Mbps: 500, Cps: 20, Retransmits: 7})
+	tui.refresh(false)
+
+	screen := buf.String()
+	if !strings.HasPrefix(screen, "\033[H\033[2J") {
+		t.Errorf("screen not cleared: %q", screen)
+	}
+	for _, want := range []string{"10.0.0.1:8080", "read", "write", "1000", "500", "running", "aggregate: read 1000 Mbps  write 500 Mbps"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("missing %q:\n%s", want, screen)
+		}
+	}
+	if retransSupported && !strings.Contains(screen, " 7 ") {
+		t.Errorf("missing retransmits:\n%s", screen)
+	}
+
+	buf.Reset()
+	tui.StreamDone(reader, StreamResult{Bytes: 250000000, Mbps: 900})
+	tui.StreamDone(writer, StreamResult{Bytes: 125000000, Mbps: 450})
+	tui.TestDone(&Result{})
+
+	screen = buf.String()
+	for _, want := range []string{"finished", "done", "aggregate: read 900 Mbps  write 450 Mbps"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("final: missing %q:\n%s", want, screen)
+		}
+	}
+	if len(tui.aggregate) != 1 || tui.aggregate[0] != 1500 {
+		t.Errorf("aggregate history: %v", tui.aggregate)
+	}
+}
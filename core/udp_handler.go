@@ -2,45 +2,59 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 )
 
 
+// udpSweepInterval bounds how long a finished UDP session waits for its results.
+const udpSweepInterval = time.Second
+
 type udpInfo struct {
 	remote *net.UDPAddr
 	opt    Options
 	acc    *account
 	start  time.Time
 	id     int
+	verify *verifyReader
+	done   bool // total duration reached
 }
 
-func ListenUDP(app *Config, wg *sync.WaitGroup, h string) {
-	log.Printf("serve: spawning UDP listener: %s", h)
+// ListenUDP spawns UDP listener on h, returning its bound address.
+func ListenUDP(ctx context.Context, app *Config, wg *sync.WaitGroup, h string) (net.Addr, error) {
+	slog.Info("listenUDP: spawning UDP listener", "addr", h)
 
 	udpAddr, errAddr := net.ResolveUDPAddr("udp", h)
 	if errAddr != nil {
-		log.Printf("listenUDP: bad address: %s: %v", h, errAddr)
-		return
+		return nil, fmt.Errorf("listenUDP: bad address: %s: %v", h, errAddr)
 	}
 
 	conn, errListen := net.ListenUDP("udp", udpAddr)
 	if errListen != nil {
-		log.Printf("net.ListenUDP: %s: %v", h, errListen)
-		return
+		return nil, fmt.Errorf("listenUDP: %s: %v", h, errListen)
 	}
 
 	wg.Add(1)
-	go handleUDP(app, wg, conn)
+	go handleUDP(ctx, app, wg, conn)
+	return conn.LocalAddr(), nil
 }
 
-func handleUDP(app *Config, wg *sync.WaitGroup, conn *net.UDPConn) {
+func handleUDP(ctx context.Context, app *Config, wg *sync.WaitGroup, conn *net.UDPConn) {
 	defer wg.Done()
 
+	var wgWriter sync.WaitGroup
+	defer wgWriter.Wait()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close() // break read loop
+	}()
+
 	tab := map[string]*udpInfo{}
 
 	buf := make([]byte, app.Opt.ReadSize)
@@ -48,40 +62,92 @@ func handleUDP(app *Config, wg *sync.WaitGroup, conn *net.UDPConn) {
 	var aggReader aggregate
 	var aggWriter aggregate
 
+	obs := app.observer()
+
 	var idCount int
 
+	// finish reports session results once total duration is reached
+	finish := func(info *udpInfo) {
+		slog.Info("handleUDP: total duration timer", "duration", info.opt.TotalDuration, "remote", info.remote)
+		info.done = true // report once
+		result := info.acc.average(info.start, &aggReader)
+		if info.verify != nil {
+			info.verify.report(fmt.Sprintf("%d/%d", info.id, 0), "handleUDP")
+			info.verify.record(&result)
+		}
+		obs.StreamDone(info.acc.stream, result)
+		app.metrics.sessionEnd("UDP")
+		slog.Debug("handleUDP: FIXME: remove idle udp entry from udp table")
+	}
+
+	lastSweep := time.Now()
+
 	for {
 		var info *udpInfo
+		conn.SetReadDeadline(time.Now().Add(udpSweepInterval))
 		n, src, errRead := conn.ReadFromUDP(buf)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// finish sessions whose client went quiet
+		if time.Since(lastSweep) >= udpSweepInterval {
+			lastSweep = time.Now()
+			for _, i := range tab {
+				if !i.done && time.Since(i.start) > i.opt.TotalDuration {
+					finish(i)
+				}
+			}
+		}
+
+		if errNet, ok := errRead.(net.Error); ok && errNet.Timeout() {
+			continue
+		}
+
 		if src == nil {
-			log.Printf("handleUDP: read nil src: error: %v", errRead)
+			slog.Error("handleUDP: read nil src", "err", errRead)
 			continue
 		}
 		var found bool
 		info, found = tab[src.String()]
 		if !found {
-			log.Printf("handleUDP: incoming: %v", src)
+			slog.Info("handleUDP: incoming", "remote", src)
 
 			info = &udpInfo{
 				remote: src,
-				acc:    &account{},
 				start:  time.Now(),
 				id:     idCount,
 			}
 			idCount++
-			info.acc.prevTime = info.start
 			tab[src.String()] = info
 
+			s := Stream{Conn: fmt.Sprintf("%d/%d", info.id, 0), Remote: src.String(), Proto: "UDP", Label: "handleUDP", Input: true}
+
 			dec := gob.NewDecoder(bytes.NewBuffer(buf[:n]))
 			if errOpt := dec.Decode(&info.opt); errOpt != nil {
-				log.Printf("handleUDP: options failure: %v", errOpt)
+				slog.Error("handleUDP: options failure", "err", errOpt)
+				app.metrics.handshakeFailure("UDP")
+				info.acc = newAccount(s, obs, info.start, 0)
+				info.done = true
 				continue
 			}
-			log.Printf("handleUDP: options received: %v", info.opt)
+			slog.Debug("handleUDP: options received", "options", info.opt)
+
+			app.metrics.sessionStart("UDP")
+
+			info.acc = newAccount(s, obs, info.start, info.opt.Omit)
+
+			if info.opt.Verify {
+				info.verify = newVerifyReader(nil, info.opt, true)
+			}
 
 			if !info.opt.PassiveServer {
 				opt := info.opt // copy for gorouting
-				go serverWriterTo(conn, opt, src, info.acc, info.id, 0, &aggWriter)
+				wgWriter.Add(1)
+				go func(start time.Time, id int) {
+					defer wgWriter.Done()
+					serverWriterTo(ctx, conn, opt, src, start, id, 0, &aggWriter, obs)
+				}(info.start, info.id)
 			}
 
 			continue
@@ -90,31 +156,35 @@ func handleUDP(app *Config, wg *sync.WaitGroup, conn *net.UDPConn) {
 		connIndex := fmt.Sprintf("%d/%d", info.id, 0)
 
 		if errRead != nil {
-			log.Printf("handleUDP: %s read error: %s: %v", connIndex, src, errRead)
+			slog.Error("handleUDP: read", "conn", connIndex, "remote", src, "err", errRead)
+			continue
+		}
+
+		if info.done {
 			continue
 		}
 
 		if time.Since(info.start) > info.opt.TotalDuration {
-			log.Printf("handleUDP: total duration %s timer: %s", info.opt.TotalDuration, src)
-			info.acc.average(info.start, connIndex, "handleUDP", "rcv/s", &aggReader)
-			log.Printf("handleUDP: FIXME: remove idle udp entry from udp table")
+			finish(info)
 			continue
 		}
 
+		if info.verify != nil {
+			info.verify.check(buf[:n])
+		}
+
 		// account read from UDP socket
-		info.acc.update(n, info.opt.ReportInterval, connIndex, "handleUDP", "rcv/s", nil)
+		info.acc.update(n, info.opt.ReportInterval)
 	}
 }
 
 
-func serverWriterTo(conn *net.UDPConn, opt Options, dst net.Addr, acc *account, c, connections int, agg *aggregate) {
-	log.Printf("serverWriterTo: starting: UDP %v", dst)
-
-	start := acc.prevTime
+func serverWriterTo(ctx context.Context, conn *net.UDPConn, opt Options, dst net.Addr, start time.Time, c, connections int, agg *aggregate, obs Observer) {
+	slog.Debug("serverWriterTo: starting", "remote", dst)
 
 	udpWriteTo := func(b []byte) (int, error) {
 		if time.Since(start) > opt.TotalDuration {
-			return -1, fmt.Errorf("udpWriteTo: total duration %s timer", opt.TotalDuration)
+			return 0, errDurationReached
 		}
 
 		return conn.WriteTo(b, dst)
@@ -123,9 +193,16 @@ func serverWriterTo(conn *net.UDPConn, opt Options, dst net.Addr, acc *account,
 	connIndex := fmt.Sprintf("%d/%d", c, connections)
 
 	buf := randBuf(opt.WriteSize)
+	if opt.Verify {
+		// every datagram carries the pattern from its beginning
+		buf = newPattern(opt.VerifySeed, opt.WriteSize)[:opt.WriteSize]
+	}
+
+	write := limitCall(udpWriteTo, opt.limit())
 
-	workLoop(connIndex, "serverWriterTo", "snd/s", udpWriteTo, buf, opt.ReportInterval, opt.MaxSpeed, nil, agg)
+	s := Stream{Conn: connIndex, Remote: dst.String(), Proto: "UDP", Label: "serverWriterTo"}
+	obs.StreamDone(s, workLoop(ctx, s, write, buf, opt.ReportInterval, opt.Omit, opt.MaxSpeed, nil, agg, obs))
 
-	log.Printf("serverWriterTo: exiting: %v", dst)
+	slog.Debug("serverWriterTo: exiting", "remote", dst)
 }
 
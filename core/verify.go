@@ -0,0 +1,132 @@
+package core
+
+import (
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+)
+
+// verifyPeriod is the length of the deterministic payload pattern.
+// A prime length keeps the pattern from aligning with buffer sizes.
+const verifyPeriod = 65521
+
+// verifyMaxOffsets limits how many corrupted offsets are recorded.
+const verifyMaxOffsets = 10
+
+// newPattern generates the deterministic payload stream for seed.
+// The pattern is extended by extra bytes so that any window of size
+// extra can be sliced from any position within the period.
+func newPattern(seed int64, extra int) []byte {
+	buf := make([]byte, verifyPeriod+extra)
+	r := rand.New(rand.NewSource(seed))
+	r.Read(buf[:verifyPeriod])
+	for i := verifyPeriod; i < len(buf); i++ {
+		buf[i] = buf[i-verifyPeriod]
+	}
+	return buf
+}
+
+// isDatagram reports whether conn preserves message boundaries.
+// Datagrams may be lost or reordered, hence every datagram carries
+// the pattern from its beginning.
+func isDatagram(conn net.Conn) bool {
+	_, udp := conn.(*net.UDPConn)
+	return udp
+}
+
+// verifyWriter sends the deterministic pattern as payload.
+type verifyWriter struct {
+	w        io.Writer
+	pattern  []byte
+	offset   int64
+	datagram bool
+}
+
+func newVerifyWriter(w io.Writer, opt Options, datagram bool) *verifyWriter {
+	return &verifyWriter{
+		w:        w,
+		pattern:  newPattern(opt.VerifySeed, opt.WriteSize),
+		datagram: datagram,
+	}
+}
+
+// write ignores the contents of p and sends the next len(p) bytes of
+// the pattern. len(p) must not exceed the write size.
+func (v *verifyWriter) write(p []byte) (int, error) {
+	start := int(v.offset % verifyPeriod)
+	n, err := v.w.Write(v.pattern[start : start+len(p)])
+	if !v.datagram && n > 0 {
+		v.offset += int64(n)
+	}
+	return n, err
+}
+
+// verifyReader checks received payload against the deterministic pattern.
+type verifyReader struct {
+	r         io.Reader
+	pattern   []byte
+	offset    int64 // position within pattern stream
+	checked   int64 // total bytes checked
+	corrupted int64 // total bytes mismatched
+	offsets   []int64
+	datagram  bool
+}
+
+func newVerifyReader(r io.Reader, opt Options, datagram bool) *verifyReader {
+	return &verifyReader{
+		r:        r,
+		pattern:  newPattern(opt.VerifySeed, 0),
+		datagram: datagram,
+	}
+}
+
+func (v *verifyReader) read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 {
+		v.check(p[:n])
+	}
+	return n, err
+}
+
+func (v *verifyReader) check(p []byte) {
+	if v.datagram {
+		v.offset = 0
+	}
+	for len(p) > 0 {
+		start := int(v.offset % verifyPeriod)
+		size := verifyPeriod - start
+		if size > len(p) {
+			size = len(p)
+		}
+		expected := v.pattern[start : start+size]
+		for i, b := range p[:size] {
+			if b != expected[i] {
+				v.corrupted++
+				if len(v.offsets) < verifyMaxOffsets {
+					v.offsets = append(v.offsets, v.checked+int64(i))
+				}
+			}
+		}
+		v.offset += int64(size)
+		v.checked += int64(size)
+		p = p[size:]
+	}
+}
+
+// record copies verification outcome into stream result.
+func (v *verifyReader) record(result *StreamResult) {
+	result.Corrupted = v.corrupted
+	if len(v.offsets) > 0 {
+		result.CorruptedOffset = v.offsets[0]
+	}
+}
+
+func (v *verifyReader) report(conn, label string) {
+	if v.corrupted == 0 {
+		logResult("verify: ok", "conn", conn, "stream", label, "checked", v.checked)
+		return
+	}
+	slog.Warn("verify: CORRUPTED", "conn", conn, "stream", label, "checked", v.checked,
+		"corrupted", v.corrupted, "offsets", v.offsets)
+}
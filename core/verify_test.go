@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestVerifyStream(t *testing.T) {
+	opt := Options{WriteSize: 7000, VerifySeed: 42}
+
+	var stream bytes.Buffer
+	w := newVerifyWriter(&stream, opt, false)
+	block := make([]byte, opt.WriteSize)
+	for i := 0; i < 30; i++ {
+		w.write(block)
+	}
+
+	data := stream.Bytes()
+	data[1000] ^= 0xff
+	data[verifyPeriod+3] ^= 0x01
+
+	r := newVerifyReader(bytes.NewReader(data), opt, false)
+	buf := make([]byte, 4096)
+	for {
+		if _, err := r.read(buf); err != nil {
+			break
+		}
+	}
+
+	if r.checked != int64(len(data)) {
+		t.Errorf("checked=%d wanted=%d", r.checked, len(data))
+	}
+	if r.corrupted != 2 {
+		t.Errorf("corrupted=%d wanted=2", r.corrupted)
+	}
+	if len(r.offsets) != 2 || r.offsets[0] != 1000 || r.offsets[1] != verifyPeriod+3 {
+		t.Errorf("offsets=%v wanted=[1000 %d]", r.offsets, verifyPeriod+3)
+	}
+}
+
+func TestVerifyDatagram(t *testing.T) {
+	opt := Options{WriteSize: 1400, VerifySeed: 7}
+
+	var dgram bytes.Buffer
+	w := newVerifyWriter(&dgram, opt, true)
+	block := make([]byte, opt.WriteSize)
+	w.write(block)
+	first := append([]byte{}, dgram.Bytes()...)
+	dgram.Reset()
+	w.write(block)
+	if !bytes.Equal(first, dgram.Bytes()) {
+		t.Errorf("datagrams should carry the pattern from its beginning")
+	}
+
+	r := newVerifyReader(nil, opt, true)
+	r.check(first)
+	r.check(first[:100])
+	if r.corrupted != 0 {
+		t.Errorf("corrupted=%d wanted=0 offsets=%v", r.corrupted, r.offsets)
+	}
+}
+
+func TestVerifyRecvFile(t *testing.T) {
+	opt := Options{ReadSize: 4096, WriteSize: 7000, VerifySeed: 42, Verify: true}
+
+	var stream bytes.Buffer
+	w := newVerifyWriter(&stream, opt, false)
+	block := make([]byte, opt.WriteSize)
+	for i := 0; i < 3; i++ {
+		w.write(block)
+	}
+	data := stream.Bytes()
+	data[100] ^= 0xff
+
+	var file bytes.Buffer
+	var fs fileStats
+	read, verify := serverReadCall(bytes.NewReader(data), opt, &file, &fs)
+	buf := make([]byte, opt.ReadSize)
+	for {
+		if _, err := read(buf); err != nil {
+			break
+		}
+	}
+
+	if verify == nil || verify.checked != int64(len(data)) || verify.corrupted != 1 {
+		t.Errorf("payload written to file was not verified: %+v", verify)
+	}
+	if !bytes.Equal(file.Bytes(), data) || fs.size != int64(len(data)) {
+		t.Errorf("file size=%d wanted=%d", file.Len(), len(data))
+	}
+}
+
+func TestVerifyCorruptedResult(t *testing.T) {
+	// server sending corrupted payload and reporting corrupted upload
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatalf("listen: %v", errListen)
+	}
+	defer listener.Close()
+	go func() {
+		conn, errAccept := listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		defer conn.Close()
+		var opt Options
+		if gob.NewDecoder(exactReader{conn}).Decode(&opt) != nil {
+			return
+		}
+		ackSend(false, conn, newAck())
+		var payload bytes.Buffer
+		newVerifyWriter(&payload, opt, false).write(make([]byte, opt.WriteSize))
+		payload.Bytes()[10] ^= 0xff
+		conn.Write(payload.Bytes())
+		received, _ := io.Copy(io.Discard, conn)
+		fin := FinalCounters{ReadBytes: received, WriteBytes: int64(payload.Len()), ReadCorrupted: 3, ReadCorruptedOffset: 7}
+		conn.Write(fin.encode())
+	}()
+
+	client := NewClient(&Config{
+		Hosts:          HostList{listener.Addr().String()},
+		Connections:    1,
+		ReportInterval: "1s",
+		Bytes:          "10KB",
+		Opt:            Options{ReadSize: 10000, WriteSize: 10000, Verify: true, VerifySeed: 1},
+	})
+
+	result, _ := client.Run(context.Background())
+	c := result.Connections[0]
+	if result.Failed() != 1 || c.Err == nil || !strings.Contains(c.Err.Error(), "payload corrupted") {
+		t.Errorf("corrupted connection not failed: %v", c.Err)
+	}
+	if c.Input.Corrupted != 1 || c.Input.CorruptedOffset != 10 {
+		t.Errorf("input: corrupted=%d offset=%d wanted=1 10", c.Input.Corrupted, c.Input.CorruptedOffset)
+	}
+	if c.Output.Corrupted != 3 || c.Output.CorruptedOffset != 7 {
+		t.Errorf("output: corrupted=%d offset=%d wanted=3 7", c.Output.Corrupted, c.Output.CorruptedOffset)
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	_ "embed" // web page
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed web/index.html
+var webPage []byte
+
+// webHistory is the number of recent events replayed to new viewers.
+const webHistory = 5000
+
+// webEvent is sent to web viewers for every stream sample or completion.
+type webEvent struct {
+	Type    string  `json:"type"` // sample or done
+	Conn    string  `json:"conn"`
+	Remote  string  `json:"remote"`
+	Stream  string  `json:"stream"`
+	Input   bool    `json:"input"`
+	Time    int64   `json:"time"` // unix milliseconds
+	Mbps    float64 `json:"mbps"` // last report interval, or average when done
+	Omitted bool    `json:"omitted,omitempty"`
+}
+
+// webObserver serves live throughput graphs to browsers, pushing stream
+// samples as server-sent events.
+type webObserver struct {
+	NopObserver
+	ctx         context.Context // ends event streams on shutdown
+	mode        string          // client or server
+	mutex       sync.Mutex
+	history     [][]byte
+	subscribers map[chan []byte]struct{}
+}
+
+func newWebObserver(ctx context.Context, mode string) *webObserver {
+	return &webObserver{ctx: ctx, mode: mode, subscribers: map[chan []byte]struct{}{}}
+}
+
+// serveWeb spawns web UI for app on Config.Web until ctx is done.
+func serveWeb(ctx context.Context, wg *sync.WaitGroup, app *Config, mode string) error {
+	app.web = newWebObserver(ctx, mode)
+	_, errWeb := serveHandler(ctx, wg, app.Web, "/", app.web)
+	return errWeb
+}
+
+func (o *webObserver) publish(e webEvent) {
+	buf, errMarshal := json.Marshal(e)
+	if errMarshal != nil {
+		slog.Error("webObserver: encoding event", "err", errMarshal)
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.history = append(o.history, buf)
+	if len(o.history) > webHistory {
+		o.history = o.history[len(o.history)-webHistory:]
+	}
+
+	for ch := range o.subscribers {
+		select {
+		case ch <- buf:
+		default: // slow viewer misses event
+		}
+	}
+}
+
+func (o *webObserver) Sample(s Stream, sample Sample) {
+	o.publish(webEvent{Type: "sample", Conn: s.Conn, Remote: s.Remote, Stream: s.Label, Input: s.Input,
+		Time: sample.Time.UnixMilli(), Mbps: sample.Mbps, Omitted: sample.Omitted})
+}
+
+func (o *webObserver) StreamDone(s Stream, result StreamResult) {
+	o.publish(webEvent{Type: "done", Conn: s.Conn, Remote: s.Remote, Stream: s.Label, Input: s.Input,
+		Time: time.Now().UnixMilli(), Mbps: float64(result.Mbps)})
+}
+
+// subscribe registers viewer, returning events seen so far.
+func (o *webObserver) subscribe() (chan []byte, [][]byte) {
+	ch := make(chan []byte, 100)
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.subscribers[ch] = struct{}{}
+	return ch, append([][]byte{}, o.history...)
+}
+
+func (o *webObserver) unsubscribe(ch chan []byte) {
+	o.mutex.Lock()
+	delete(o.subscribers, ch)
+	o.mutex.Unlock()
+}
+
+func (o *webObserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webPage)
+	case "/events":
+		o.events(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// events streams samples to viewer as server-sent events.
+func (o *webObserver) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, history := o.subscribe()
+	defer o.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	fmt.Fprintf(w, "event: mode\ndata: %q\n\n", o.mode)
+	for _, buf := range history {
+		fmt.Fprintf(w, "data: %s\n\n", buf)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case buf := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", buf)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-o.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebObserver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	web := newWebObserver(ctx, "client")
+	ts := httptest.NewServer(web)
+	defer ts.Close()
+
+	resp, errPage := http.Get(ts.URL + "/")
+	if errPage != nil {
+		t.Fatalf("page: %v", errPage)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "EventSource") {
+		t.Errorf("page: missing event source")
+	}
+
+	s := Stream{Conn: "0/1", Remote: "127.0.0.1:8080", Proto: "TCP", Label: "clientReader", Input: true}
+	web.Sample(s, Sample{Time: time.Now(), Bytes: 1000, Mbps: 100})
+
+	events, errEvents := http.Get(ts.URL + "/events")
+	if errEvents != nil {
+		t.Fatalf("events: %v", errEvents)
+	}
+	defer events.Body.Close()
+	if ct := events.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content-type=%q", ct)
+	}
+
+	// sample published before subscription is replayed, later done is pushed live
+	go web.StreamDone(s, StreamResult{Bytes: 2000, Mbps: 150})
+
+	var got []webEvent
+	scanner := bufio.NewScanner(events.Body)
+	for len(got) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if line == `data: "client"` || !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var e webEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			t.Fatalf("event %q: %v", line, err)
+		}
+		got = append(got, e)
+	}
+
+	if len(got) != 2 || got[0].Type != "sample" || got[0].Mbps != 100 || got[1].Type != "done" || got[1].Mbps != 150 {
+		t.Errorf("events: %+v", got)
+	}
+	if got[0].Conn != s.Conn || got[0].Stream != s.Label || !got[0].Input {
+		t.Errorf("event stream: %+v", got[0])
+	}
+}
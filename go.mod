@@ -10,4 +10,4 @@ require (
 	gopkg.in/yaml.v2 v2.2.2
 )
 
-go 1.13
+go 1.21
@@ -1,26 +1,96 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/udhos/goben/core"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
 	"runtime"
-	"strconv"
-	"time"
+	"syscall"
 )
 
+func flagIsSet(name string) bool {
+	var found bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// tlsModeFlag sets TLS mode, also accepting bool values for compatibility.
+// It is not a bool flag, so that "-tls required" takes its argument.
+type tlsModeFlag struct {
+	mode *string
+}
+
+func (f tlsModeFlag) String() string {
+	if f.mode == nil {
+		return ""
+	}
+	return *f.mode
+}
+
+// Set stores mode, later validated by Config.Setup.
+func (f tlsModeFlag) Set(value string) error {
+	switch value {
+	case "true":
+		value = core.TLSOptional
+	case "false":
+		value = core.TLSOff
+	}
+	*f.mode = value
+	return nil
+}
 
+// interruptible cancels context on first signal, and aborts on second one.
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		s := <-sig
+		slog.Warn("received signal: stopping, send again to abort", "signal", s)
+		cancel()
+		s = <-sig
+		slog.Warn("received signal: aborting", "signal", s)
+		os.Exit(1)
+	}()
+
+	return ctx
+}
+
+// fatal logs err and exits with failure status.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
 
 func main() {
 
 	app := core.Config{}
 
+	var quiet, verbose, debug, logJSON bool
+
 	flag.Var(&app.Hosts, "hosts", "comma-separated list of hosts\nyou may append an optional port to every host: host[:port]")
 	flag.Var(&app.Listeners, "listeners", "comma-separated list of listen addresses\nyou may prepend an optional host to every port: [host]:port")
 	flag.StringVar(&app.DefaultPort, "defaultPort", ":8080", "default port")
 	flag.IntVar(&app.Connections, "connections", 1, "number of parallel connections")
+	flag.Float64Var(&app.ConnectRate, "connectRate", 0, "client opens at most this many connections per second, ramping up towards -connections (0 means unlimited)")
+	flag.IntVar(&app.DialConcurrency, "dialConcurrency", 1, "client connection attempts in progress at once")
+	flag.StringVar(&app.DialTimeout, "dialTimeout", "", "client connect timeout, including TLS handshake (empty means 10s, 0 means none)")
+	flag.IntVar(&app.DialRetries, "dialRetries", 0, "client retries failed connection attempts, pausing one second between them")
 	flag.StringVar(&app.ReportInterval, "reportInterval", "2s", "periodic report interval\nunspecified time unit defaults to second")
 	flag.StringVar(&app.TotalDuration, "totalDuration", "10s", "test total duration\nunspecified time unit defaults to second")
+	flag.StringVar(&app.Omit, "omit", "0", "omit initial period (TCP slow start) from averages and exported results\nunspecified time unit defaults to second")
+	flag.StringVar(&app.Bytes, "bytes", "", "stop each stream after transferring this many bytes\nunits: K, M, G, T (decimal) or KiB, MiB, GiB, TiB\nexample: -bytes 10GB")
+	flag.Int64Var(&app.Opt.BlockCount, "blockCount", 0, "stop each stream after writing this many blocks of writeSize bytes (0 means unlimited)")
 	flag.IntVar(&app.Opt.ReadSize, "readSize", 50000, "read buffer size in bytes")
 	flag.IntVar(&app.Opt.WriteSize, "writeSize", 50000, "write buffer size in bytes")
 	flag.BoolVar(&app.PassiveClient, "passiveClient", false, "suppress client writes")
@@ -33,50 +103,93 @@ func main() {
 	flag.BoolVar(&app.Ascii, "ascii", true, "plot ascii chart")
 	flag.StringVar(&app.TlsKey, "key", "key.pem", "TLS key file")
 	flag.StringVar(&app.TlsCert, "cert", "cert.pem", "TLS cert file")
-	flag.BoolVar(&app.Tls, "tls", true, "set to false to disable TLS")
+	app.TlsMode = core.TLSOptional
+	flag.Var(tlsModeFlag{&app.TlsMode}, "tls", "TLS `mode`: required, optional or off\nrequired: client never falls back to plain TCP, server refuses plain TCP and UDP\noptional: client falls back to plain TCP, server accepts both on same port\ntrue means optional, false means off")
+	flag.StringVar(&app.TlsCA, "ca", "", "PEM CA bundle for verifying server certificate (implies -tlsVerify unless set)")
+	flag.StringVar(&app.TlsServerName, "tlsServerName", "", "server name for TLS SNI and certificate verification (defaults to host)")
+	flag.StringVar(&app.TlsClientCA, "clientCA", "", "PEM CA bundle: server requires TLS clients to present certificates signed by it\nplain TCP and UDP are refused")
+	flag.StringVar(&app.TlsClientCert, "clientCert", "", "client certificate file presented to server (requires -clientKey)")
+	flag.StringVar(&app.TlsClientKey, "clientKey", "", "client certificate key file")
+	flag.BoolVar(&app.TlsSelfSigned, "selfSigned", false, "server generates in-memory self-signed TLS certificate, printing its fingerprint\ninstead of loading -key and -cert")
+	flag.StringVar(&app.TlsPin, "tlsPin", "", "client requires server certificate with this SHA-256 fingerprint, as printed by -selfSigned server")
+	flag.StringVar(&app.TlsMinVersion, "tlsMinVersion", "", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default is Go default)")
+	flag.StringVar(&app.TlsMaxVersion, "tlsMaxVersion", "", "maximum TLS version: 1.0, 1.1, 1.2 or 1.3 (default is Go default)")
+	flag.StringVar(&app.TlsCiphers, "tlsCiphers", "", "comma-separated TLS 1.0-1.2 cipher suites, e.g. TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256\nTLS 1.3 suites are not configurable, combine with -tlsMaxVersion 1.2")
+	flag.BoolVar(&app.TlsResume, "tlsResume", false, "client resumes TLS sessions with session tickets")
+	flag.BoolVar(&app.Handshakes, "handshakes", false, "client measures TLS handshake rate instead of throughput, opening short connections\nuntil -totalDuration with -connections concurrent workers per host (see -tlsResume)")
+	flag.BoolVar(&app.Cps, "cps", false, "client measures TCP connection rate instead of throughput, opening and closing connections\nuntil -totalDuration with -connections concurrent workers per host (see -cpsPayload)")
+	flag.IntVar(&app.CpsPayload, "cpsPayload", 0, "bytes sent and echoed back by server on every -cps connection (0 means connect and close)")
+	flag.StringVar(&app.TlsALPN, "tlsALPN", "", "comma-separated ALPN protocols offered by client or accepted by server")
+	flag.BoolVar(&app.TlsVerify, "tlsVerify", false, "client verifies server certificate against -ca or system roots\nverification failure fails the connection instead of falling back to plain TCP")
+	flag.BoolVar(&app.Opt.Verify, "verify", false, "send deterministic payload and verify integrity of received data\ncorrupted data in either direction fails the connection")
+	flag.StringVar(&app.SendFile, "sendFile", "", "client sends this file instead of random data (implies -passiveServer)\n'-' means stdin")
+	flag.StringVar(&app.RecvFile, "recvFile", "", "server writes received data to this file\n'%d' is parallel connection index\n'%s' is hostname:port\nexample: -recvFile recv-%d-%s.dat or -recvFile /dev/null")
 	flag.StringVar(&app.LocalAddr, "localAddr", "", "bind specific local address:port\nexample: -localAddr 127.0.0.1:2000")
+	flag.StringVar(&app.Metrics, "metrics", "", "HTTP address for Prometheus /metrics endpoint on server or monitoring client\nexample: -metrics :9100")
+	flag.BoolVar(&app.Tui, "tui", false, "client shows live dashboard of every connection instead of log lines (implies -ascii=false)")
+	flag.StringVar(&app.Web, "web", "", "HTTP address for web UI with live throughput graphs on client or server\nexample: -web 127.0.0.1:8091")
+	flag.StringVar(&app.Api, "api", "", "HTTP address for REST API on server, starting client tests and returning results as JSON\nrequests must carry -agentSecret as bearer token\nexample: -api 127.0.0.1:8090")
+	flag.StringVar(&app.Monitor, "monitor", "", "client repeats test to every host on this interval until interrupted\nunspecified time unit defaults to second\nexample: -monitor 5m -totalDuration 10s")
+	flag.IntVar(&app.MonitorHistory, "monitorHistory", 100, "results kept per host in monitor mode")
+	flag.StringVar(&app.MonitorFile, "monitorFile", "", "monitor appends results as JSON lines to this file")
+	flag.IntVar(&app.MonitorCount, "monitorCount", 0, "monitor stops after testing every host this many times (0 means until interrupted)")
+	flag.BoolVar(&app.Agent, "agent", false, "server accepts control requests to run tests towards other agents")
+	flag.StringVar(&app.AgentSecret, "agentSecret", "", "shared secret authenticating control requests between agents and controllers, and REST API requests\ndefaults to environment variable GOBEN_AGENT_SECRET")
+	flag.StringVar(&app.ControlAgent, "controlAgent", "", "client asks this agent to run test towards every host, instead of testing itself\nexample: -controlAgent site1 -hosts site2")
+	flag.BoolVar(&app.Mesh, "mesh", false, "client coordinates tests between every pair of hosts, which must be servers running with -agent")
+	flag.BoolVar(&app.MeshParallel, "meshParallel", false, "mesh runs all tests at once instead of one after another")
+	flag.StringVar(&app.MeshReport, "meshReport", "", "mesh writes results matrix as JSON to this file")
+	flag.BoolVar(&quiet, "quiet", false, "log only test results, warnings and errors")
+	flag.BoolVar(&verbose, "verbose", false, "log protocol tracing")
+	flag.BoolVar(&debug, "debug", false, "same as -verbose")
+	flag.BoolVar(&logJSON, "logJSON", false, "log in JSON format")
 
 	flag.Parse()
 
-	if errChart := core.BadExportFilename("-chart", app.Chart); errChart != nil {
-		log.Panicf("%s", errChart.Error())
+	if app.Tui {
+		app.Ascii = false
 	}
 
-	if errExport := core.BadExportFilename("-export", app.Export); errExport != nil {
-		log.Panicf("%s", errExport.Error())
+	if app.TlsCA != "" && flagIsSet("tlsVerify") && !app.TlsVerify {
+		app.TlsNoVerify = true // -tlsVerify=false overrides CA
 	}
 
-	if errCsv := core.BadExportFilename("-csv", app.Csv); errCsv != nil {
-		log.Panicf("%s", errCsv.Error())
+	if app.AgentSecret == "" {
+		app.AgentSecret = os.Getenv("GOBEN_AGENT_SECRET") // keep secret off command line
 	}
 
-	app.ReportInterval = core.DefaultTimeUnit(app.ReportInterval)
-	app.TotalDuration = core.DefaultTimeUnit(app.TotalDuration)
-
-	var errInterval error
-	app.Opt.ReportInterval, errInterval = time.ParseDuration(app.ReportInterval)
-	if errInterval != nil {
-		log.Panicf("bad reportInterval: %q: %v", app.ReportInterval, errInterval)
+	level := slog.LevelInfo
+	switch {
+	case verbose || debug:
+		level = slog.LevelDebug
+	case quiet:
+		level = core.LevelResult
+	case app.Tui:
+		level = slog.LevelWarn // dashboard replaces progress and results
 	}
+	slog.SetDefault(core.NewLogger(os.Stderr, level, logJSON))
 
-	var errDuration error
-	app.Opt.TotalDuration, errDuration = time.ParseDuration(app.TotalDuration)
-	if errDuration != nil {
-		log.Panicf("bad totalDuration: %q: %v", app.TotalDuration, errDuration)
+	if app.Bytes != "" || app.Opt.BlockCount > 0 || app.SendFile != "" {
+		if !app.Udp && !flagIsSet("totalDuration") {
+			app.TotalDuration = "0" // size-limited test runs to completion
+		}
 	}
 
-	if len(app.Listeners) == 0 {
-		app.Listeners = []string{app.DefaultPort}
+	if errSetup := app.Setup(); errSetup != nil {
+		fatal(errSetup)
 	}
 
-	log.Printf("goben version " + core.Version + " runtime " + runtime.Version() + " GOMAXPROCS=" + strconv.Itoa(runtime.GOMAXPROCS(0)))
-	log.Printf("connections=%d defaultPort=%s listeners=%q hosts=%q",
-		app.Connections, app.DefaultPort, app.Listeners, app.Hosts)
-	log.Printf("reportInterval=%s totalDuration=%s", app.Opt.ReportInterval, app.Opt.TotalDuration)
+	slog.Info("goben", "version", core.Version, "runtime", runtime.Version(), "GOMAXPROCS", runtime.GOMAXPROCS(0))
+	slog.Info("config", "connections", app.Connections, "defaultPort", app.DefaultPort, "listeners", app.Listeners, "hosts", app.Hosts)
+	slog.Info("config", "reportInterval", app.Opt.ReportInterval, "totalDuration", app.Opt.TotalDuration, "omit", app.Opt.Omit, "bytes", app.Opt.MaxBytes, "blockCount", app.Opt.BlockCount)
 
 	if len(app.Hosts) == 0 {
-		log.Printf("server mode (use -hosts to switch to client mode)")
-		core.Serve(&app)
+		slog.Info("server mode (use -hosts to switch to client mode)")
+		server := core.NewServer(&app)
+		if errStart := server.Start(interruptible()); errStart != nil {
+			fatal(errStart)
+		}
+		server.Wait()
 		return
 	}
 
@@ -87,7 +200,50 @@ func main() {
 		proto = "tcp"
 	}
 
-	log.Printf("client mode, %s protocol", proto)
-	core.Open(&app)
-}
+	slog.Info("client mode", "proto", proto)
+
+	if app.Mesh {
+		result, errMesh := core.NewMesh(&app).Run(interruptible())
+		if result != nil {
+			result.Print(os.Stdout)
+		}
+		if errMesh != nil && errMesh != context.Canceled {
+			fatal(errMesh)
+		}
+		return
+	}
+
+	if app.ControlAgent != "" {
+		ctx := interruptible()
+		for _, h := range app.Hosts {
+			if _, errControl := core.Control(ctx, &app, app.ControlAgent, h); errControl != nil && errControl != context.Canceled {
+				fatal(errControl)
+			}
+		}
+		return
+	}
+
+	if app.Handshakes || app.Cps {
+		if _, errRate := core.NewRateTest(&app).Run(interruptible()); errRate != nil && errRate != context.Canceled {
+			fatal(errRate)
+		}
+		return
+	}
+
+	if app.Monitor != "" {
+		if errMonitor := core.NewMonitor(&app).Run(interruptible()); errMonitor != nil {
+			fatal(errMonitor)
+		}
+		return
+	}
 
+	result, errRun := core.NewClient(&app).Run(interruptible())
+	if errRun != nil && errRun != context.Canceled {
+		fatal(errRun)
+	}
+	if result != nil {
+		if failed := result.Failed(); failed > 0 {
+			fatal(fmt.Errorf("client: %d of %d connections failed", failed, len(result.Connections)))
+		}
+	}
+}